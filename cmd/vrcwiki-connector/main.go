@@ -3,38 +3,98 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
-
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/atomicfile"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/botconfig"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/breaker"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/checkpoint"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/leader"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/outbox"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/retry"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/safety"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/scheduler"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/secrets"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/tracing"
 	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
 	mw "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/mediawiki"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/sources"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/wikisync"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// minimal SSE event
-type sseEvent struct {
-	Event string
-	Data  string
+var tracer = otel.Tracer("github.com/hackebein/vpmm/apps/vrcwiki-connector/cmd/vrcwiki-connector")
+
+// eventCounters counts SSE events the connector could not act on, across all
+// sources.
+type eventCounters struct {
+	unknown   atomic.Int64
+	malformed atomic.Int64
 }
 
 func main() {
-	logger := log.New(os.Stdout, "vrcwiki-connector ", log.LstdFlags)
+	// every log line passes through the redactor so credentials never reach the output
+	redactor := &secrets.Redactor{}
+	logger, err := newLogger(redactor)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vrcwiki-connector: %v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	// tracing exports to OTEL_EXPORTER_OTLP_ENDPOINT when set and is a no-op otherwise
+	shutdownTracing, err := tracing.Setup(context.Background(), "vrcwiki-connector")
+	if err != nil {
+		fatal(logger, "init tracing", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("flush traces", "error", err)
+		}
+	}()
 
-	vpmmBaseURL := "http://api:8080"
-	sseURL := strings.TrimRight(vpmmBaseURL, "/") + "/sse"
+	wikiConfig, err := loadWikiConfig()
+	if err != nil {
+		fatal(logger, "load wiki config", err)
+	}
+	wikiConfig.Logger = logger
+	// transient failures of wiki and VPMM requests are retried with backoff
+	retryPolicy, err := loadRetryPolicy()
+	if err != nil {
+		fatal(logger, "load retry policy", err)
+	}
+	wikiConfig.Retry = retryPolicy
+	redactor.Set(wikiSecrets(wikiConfig)...)
+	wikiBackend := os.Getenv("VRCWIKI_BACKEND")
+	wikiOutputDir := os.Getenv("VRCWIKI_OUTPUT_DIR")
 
-	wikiAPI := os.Getenv("VRCWIKI_API_URL")
-	wikiUser := os.Getenv("VRCWIKI_USERNAME")
-	wikiPass := os.Getenv("VRCWIKI_PASSWORD")
-	wikiHdrName := os.Getenv("VRCWIKI_AUTHORIZATION_HEADER")
-	wikiHdrValue := os.Getenv("VRCWIKI_AUTHORIZATION_VALUE")
+	// optional maintenance report outputs
+	maintenance := maintenanceConfig{
+		Page:     strings.TrimSpace(os.Getenv("VRCWIKI_MAINTENANCE_PAGE")),
+		JSONPath: strings.TrimSpace(os.Getenv("VRCWIKI_MAINTENANCE_JSON")),
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -42,145 +102,990 @@ func main() {
 	httpClient := &http.Client{Timeout: 60 * time.Second}
 	sseClient := &http.Client{Timeout: 0 * time.Second}
 
-	wikiClient, err := mw.NewMediaWikiClient(mw.WikiConfig{
-		URL:       wikiAPI,
-		Username:  wikiUser,
-		Password:  wikiPass,
-		Header:    wikiHdrName,
-		HeaderVal: wikiHdrValue,
-	}, httpClient)
+	var store pagestore.PageStore
+	var wikiClient *mw.MediaWikiClient
+	backend := strings.ToLower(strings.TrimSpace(wikiBackend))
+	if backend == "" {
+		// without credentials the connector mirrors the wiki to files, as
+		// it did before backends could be chosen
+		backend = "mediawiki"
+		if strings.TrimSpace(wikiConfig.Username) == "" && strings.TrimSpace(wikiConfig.Password) == "" {
+			backend = "filesystem"
+		}
+	}
+	switch backend {
+	case "mediawiki":
+		wikiClient, err = mw.NewMediaWikiClient(wikiConfig, httpClient)
+		if err != nil {
+			fatal(logger, "init wiki client", err)
+		}
+		// fail fast instead of editing without the bot flag or as an anonymous
+		// IP; if the wiki cannot be asked, carry on like a credential reload
+		// does: writes still assert a logged-in user
+		switch info, err := wikiClient.VerifyRights(ctx, mw.RequiredRights...); {
+		case errors.Is(err, mw.ErrMissingRights):
+			fatal(logger, "verify wiki rights", err)
+		case err != nil:
+			logger.Warn("verify wiki rights: wiki not reachable, continuing", "error", err)
+		default:
+			logger.Info("wiki account verified", "user", info.Name, "groups", info.Groups)
+		}
+		store = wikiClient
+	case "filesystem":
+		if strings.TrimSpace(wikiOutputDir) == "" {
+			wikiOutputDir = "./wiki-output"
+		}
+		logger.Info("filesystem backend enabled: mirroring wiki pages", "dir", wikiOutputDir)
+		store = pagestore.NewFileStore(wikiOutputDir)
+	case "memory":
+		store = pagestore.NewMemoryStore()
+	default:
+		fatal(logger, "unknown wiki backend", fmt.Errorf("%q", wikiBackend))
+	}
+
+	// SIGHUP re-reads the credentials (e.g. after a mounted secret was rotated)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	// package metadata sources, in order of precedence
+	sourceConfigs, err := loadSourceConfigs()
+	if err != nil {
+		fatal(logger, "load sources", err)
+	}
+	srcs, err := sources.NewAll(sourceConfigs, apiclient.NewRetryingDoer(httpClient, retryPolicy, logger))
 	if err != nil {
-		logger.Fatalf("init wiki client: %v", err)
+		fatal(logger, "init sources", err)
 	}
 
-	// debouncer for full syncs
-	syncDelay := 30 * time.Second
-	syncTimer := time.NewTimer(syncDelay)
-	// initial sync after delay
-	resetTimer := func() {
-		if !syncTimer.Stop() {
-			select {
-			case <-syncTimer.C:
-			default:
-			}
+	// polling covers VPMM sources while their SSE stream is down; the
+	// reconciliation runs a complete sync even when every stream is healthy
+	fallbackPoll, err := durationEnv("VRCWIKI_FALLBACK_POLL_INTERVAL", 5*time.Minute)
+	if err != nil {
+		fatal(logger, "load fallback poll interval", err)
+	}
+	reconcileEvery, err := durationEnv("VRCWIKI_RECONCILE_INTERVAL", 6*time.Hour)
+	if err != nil {
+		fatal(logger, "load reconcile interval", err)
+	}
+
+	// streams without events or heartbeats for this long are reconnected
+	sseIdleTimeout, err := durationEnv("VRCWIKI_SSE_IDLE_TIMEOUT", 2*time.Minute)
+	if err != nil {
+		fatal(logger, "load sse idle timeout", err)
+	}
+
+	// sync timing: wait for a quiet period, but never longer than the max
+	// wait, and keep a minimum interval between runs
+	var schedCfg scheduler.Config
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+		def time.Duration
+	}{
+		{"VRCWIKI_SYNC_QUIET_PERIOD", &schedCfg.Quiet, scheduler.DefaultQuiet},
+		{"VRCWIKI_SYNC_MAX_WAIT", &schedCfg.MaxWait, scheduler.DefaultMaxWait},
+		{"VRCWIKI_SYNC_MIN_INTERVAL", &schedCfg.MinInterval, scheduler.DefaultMinInterval},
+	} {
+		if *d.dst, err = durationEnv(d.env, d.def); err != nil {
+			fatal(logger, "load sync schedule", err)
+		}
+	}
+	// on SIGTERM the running sync finishes the package in progress and the
+	// rest is checkpointed; once the grace period is over, in-flight requests
+	// are cancelled too. Keep it below terminationGracePeriodSeconds.
+	shutdownGrace, err := durationEnv("VRCWIKI_SHUTDOWN_GRACE", 25*time.Second)
+	if err != nil {
+		fatal(logger, "load shutdown grace period", err)
+	}
+	checkpointPath := strings.TrimSpace(os.Getenv("VRCWIKI_CHECKPOINT_FILE"))
+	if checkpointPath == "" {
+		if dir, err := stateDir(); err != nil {
+			logger.Warn("checkpointing disabled", "error", err)
+		} else {
+			checkpointPath = filepath.Join(dir, "checkpoint.json")
+		}
+	}
+
+	// event callbacks only add triggers, so the streams never wait for a
+	// running sync
+	sched := scheduler.New(schedCfg)
+	defer sched.Stop()
+	// the first run always processes every package
+	sched.Add(ctx, scheduler.Trigger{Reason: "startup", Full: true})
+	resumed := resumeCheckpoint(ctx, checkpointPath, sched, logger)
+
+	// with several replicas only the elected leader writes; standbys keep
+	// their streams connected and drop their batches until they take over
+	elector, err := newElector(store, logger, func() {
+		sched.Add(ctx, scheduler.Trigger{Reason: "leader.elected", Full: true, Urgent: true})
+	})
+	if err != nil {
+		fatal(logger, "init leader election", err)
+	}
+	isLeader := func() bool { return elector == nil || elector.IsLeader() }
+	// the lock is released last, after shutdown has stopped the sync
+	electionCtx, stopElection := context.WithCancel(context.WithoutCancel(ctx))
+	var election sync.WaitGroup
+	if elector != nil {
+		election.Go(func() { elector.Run(electionCtx) })
+	}
+	defer func() {
+		stopElection()
+		election.Wait()
+	}()
+
+	// while the wiki is down or read-only, syncs stop and the writes under
+	// way wait in the outbox; it is delivered before syncing resumes
+	outboxPath := strings.TrimSpace(os.Getenv("VRCWIKI_OUTBOX_FILE"))
+	if outboxPath == "" {
+		if dir, err := stateDir(); err != nil {
+			logger.Warn("outbox kept in memory only", "error", err)
+		} else {
+			outboxPath = filepath.Join(dir, "outbox.json")
 		}
-		syncTimer.Reset(syncDelay)
 	}
-	resetTimer()
+	box, err := outbox.Open(outboxPath)
+	if err != nil {
+		fatal(logger, "open outbox", err)
+	}
+	breakerCfg, err := loadBreakerConfig()
+	if err != nil {
+		fatal(logger, "load circuit breaker config", err)
+	}
+	wiki := outbox.NewStore(store, box, outbox.Config{
+		Breaker: breakerCfg,
+		OnRecovered: func() {
+			sched.Add(ctx, scheduler.Trigger{Reason: "wiki.recovered", Full: true, Urgent: true})
+		},
+		Active: isLeader,
+		Logger: logger,
+	})
+	if n := wiki.Queued(); n > 0 {
+		logger.Info("outbox holds writes of a previous run", "queued", n)
+	}
+	go wiki.Run(ctx)
+	syncer, err := configureSyncer(wikisync.NewSyncer(wiki, logger), logger)
+	if err != nil {
+		fatal(logger, "load package filters", err)
+	}
 
-	// initialize generated API client
-	cli, err := apiclient.NewClientWithResponses(vpmmBaseURL, apiclient.WithHTTPClient(httpClient))
+	// wiki admins can pause the bot, exclude packages, pin fields and
+	// request syncs on the config page
+	configPoll, err := durationEnv("VRCWIKI_CONFIG_POLL_INTERVAL", time.Minute)
 	if err != nil {
-		logger.Fatalf("init api client: %v", err)
+		fatal(logger, "load bot config settings", err)
 	}
+	botCfg := botconfig.NewSource(wiki, strings.TrimSpace(os.Getenv("VRCWIKI_CONFIG_PAGE")), logger)
+	logger.Info("bot config page configured", "page", botCfg.Title(), "poll_interval", configPoll)
+	go pollBotConfig(ctx, botCfg, configPoll, func() bool { return isLeader() && !wiki.Paused() }, sched)
 
-	// SSE loop with backoff
-	events := make(chan sseEvent, 8)
-	var lastID string
-	go func() {
-		defer close(events)
-		backoff := time.Second
-		for {
-			if ctx.Err() != nil {
-				return
+	// a tripped guard stops all syncs until an operator overrides it
+	guard, err := loadSafetyGuard(logger)
+	if err != nil {
+		fatal(logger, "load safety limits", err)
+	}
+	if trip := guard.Tripped(); trip != nil {
+		logger.Error("safety limit tripped by an earlier run: syncing stays stopped until restarted with VRCWIKI_SAFETY_OVERRIDE=1",
+			"reason", trip.Reason, "tripped_at", trip.At, "sync_run", trip.RunID)
+	}
+
+	counters := &eventCounters{}
+	for _, src := range srcs {
+		logger.Info("package source configured", "source", src.Name, "kind", src.Kind, "url", src.URL)
+		if sseURL := src.SSEURL(); sseURL != "" {
+			stream := sourceStream(ctx, src, sseURL, sseClient, sseIdleTimeout, sched, counters, logger)
+			go stream.Run(ctx)
+			go pollSource(ctx, src, fallbackPoll, func() bool { return stream.State().State != apiclient.StateConnected }, sched)
+		} else {
+			go pollSource(ctx, src, src.PollInterval, nil, sched)
+		}
+	}
+	reconcile := time.NewTicker(reconcileEvery)
+	defer reconcile.Stop()
+
+	// index remembers the last processed listings between runs; only the
+	// sync goroutine touches it
+	index := indexState{sources: map[string]*sourceState{}}
+
+	// running tracks the background sync so shutdown can wait for it. Runs
+	// do not use ctx: stopSync ends them between packages, cancelRuns aborts
+	// them when the grace period is over.
+	var running sync.WaitGroup
+	stopSync := make(chan struct{})
+	runBase, cancelRuns := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRuns()
+	// interruptedRun is the ID of the run stopped by shutdown, if any
+	var interruptedRun string
+	startSync := func(batch scheduler.Batch) {
+		runID := logging.NewRunID()
+		runCtx := logging.With(runBase, logging.KeySyncRun, runID)
+		var links []trace.Link
+		if batch.Debounce.IsValid() {
+			links = append(links, trace.Link{SpanContext: batch.Debounce})
+		}
+		runCtx, span := tracer.Start(runCtx, "sync.full", trace.WithLinks(links...),
+			trace.WithAttributes(attribute.String("sync.run", runID), attribute.Bool("sync.reconcile", batch.Full),
+				attribute.Int("sync.dirty_packages", len(batch.Packages)), attribute.Int("sync.triggers", batch.Triggers),
+				attribute.Int64("sync.waited_ms", batch.Waited.Milliseconds())))
+		logger.InfoContext(runCtx, "running wiki full sync", "reconcile", batch.Full, "dirty_packages", len(batch.Packages),
+			"triggers", batch.Triggers, "waited", batch.Waited)
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer span.End()
+			// losing the lock stops the run like a shutdown, but the batch is
+			// not checkpointed: the new leader syncs everything anyway
+			stop := func() bool {
+				return stopped(stopSync) || !isLeader() || wiki.Paused() || guard.Tripped() != nil || botCfg.Paused()
 			}
-			if err := apiclient.ListenSSE(ctx, sseURL, sseClient, &lastID, apiclient.SSEHandlers{
-				OnPackageAdded: func(event apiclient.PackageAddedEvent) {
-					events <- sseEvent{Event: "package.added", Data: event.Identifier.Name}
-				},
-				OnPackageUpdated: func(event apiclient.PackageUpdatedEvent) {
-					events <- sseEvent{Event: "package.updated", Data: event.Identifier.Name}
-				},
-				OnPackageRemoved: func(event apiclient.PackageRemovedEvent) {
-					events <- sseEvent{Event: "package.removed", Data: event.Identifier.Name}
-				},
-			}); err != nil {
-				logger.Printf("sse error: %v", err)
-				time.Sleep(backoff)
-				if backoff < 30*time.Second {
-					backoff *= 2
+			interrupted := runFullSync(runCtx, stop, srcs, syncer, &index, batch, botCfg, guard, maintenance, logger)
+			if interrupted {
+				span.SetAttributes(attribute.Bool("sync.interrupted", true))
+				if stopped(stopSync) {
+					interruptedRun = runID
+					return
 				}
-				continue
 			}
-			// normal end or server close, short pause then reconnect
-			time.Sleep(1 * time.Second)
+			if resumed && !interrupted {
+				// the checkpointed packages are done
+				if err := checkpoint.Remove(checkpointPath); err != nil {
+					logger.WarnContext(runCtx, "remove checkpoint", "error", err)
+				}
+				resumed = false
+			}
+			sched.Done()
+			logger.DebugContext(runCtx, "sync finished", "scheduler", sched.State())
+		}()
+	}
+
+	// main loop: start syncs when the scheduler says they are due
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("shutting down", "scheduler", sched.State(), "grace", shutdownGrace)
+			close(stopSync)
+			deadline := time.AfterFunc(shutdownGrace, func() {
+				logger.Warn("shutdown grace period exceeded, cancelling sync")
+				cancelRuns()
+			})
+			running.Wait()
+			deadline.Stop()
+			if isLeader() {
+				saveCheckpoint(checkpointPath, interruptedRun, sched, &index, logger)
+			}
+			return
+		case <-sched.Ready():
+			// stale signals and signals during a run are ignored; Done
+			// reschedules the batch that accumulated meanwhile
+			batch, ok := sched.Take()
+			switch {
+			case !ok:
+			case !isLeader():
+				logger.Debug("standby: skipping sync", "triggers", batch.Triggers, "identity", elector.Identity())
+				sched.Done()
+			case wiki.Paused():
+				// recovery triggers a full sync, which covers this batch
+				logger.Info("wiki unavailable: sync paused", "triggers", batch.Triggers, "breaker", wiki.Breaker(), "queued", wiki.Queued())
+				sched.Done()
+			case botCfg.Paused():
+				// resuming triggers a full sync, which covers this batch
+				logger.Info("sync paused by the wiki config page", "page", botCfg.Title(),
+					"reason", botCfg.Current().PauseReason, "triggers", batch.Triggers)
+				sched.Done()
+			case guard.Tripped() != nil:
+				logger.Error("safety limit tripped: sync refused, restart with VRCWIKI_SAFETY_OVERRIDE=1 after checking the cause",
+					"reason", guard.Tripped().Reason, "triggers", batch.Triggers)
+				sched.Done()
+			default:
+				startSync(batch)
+			}
+		case <-reload:
+			reloadWikiCredentials(ctx, wikiClient, &wikiConfig, redactor, logger)
+		case <-reconcile.C:
+			logger.Info("scheduling periodic full reconciliation")
+			sched.Add(ctx, scheduler.Trigger{Reason: "reconcile", Full: true})
 		}
-	}()
+	}
+}
+
+// stateDir returns the directory for files that must survive restarts,
+// creating it if needed: VRCWIKI_STATE_DIR, systemd's STATE_DIRECTORY or
+// vrcwiki-connector in the XDG state home.
+func stateDir() (string, error) {
+	dir := strings.TrimSpace(os.Getenv("VRCWIKI_STATE_DIR"))
+	if dir == "" {
+		// systemd lists several directories separated by colons
+		dir, _, _ = strings.Cut(os.Getenv("STATE_DIRECTORY"), ":")
+	}
+	if dir == "" {
+		base := os.Getenv("XDG_STATE_HOME")
+		if base == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("state directory: %w", err)
+			}
+			base = filepath.Join(home, ".local", "state")
+		}
+		dir = filepath.Join(base, "vrcwiki-connector")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("create state directory: %w", err)
+	}
+	return dir, nil
+}
+
+// resumeCheckpoint queues the packages of the checkpoint at path ahead of
+// everything else and starts the first run without waiting for the quiet
+// period. It reports whether there was anything to resume.
+func resumeCheckpoint(ctx context.Context, path string, sched *scheduler.Scheduler, logger *slog.Logger) bool {
+	if path == "" {
+		return false
+	}
+	cp, err := checkpoint.Load(path)
+	if err != nil {
+		logger.Warn("ignoring checkpoint", "error", err)
+		return false
+	}
+	if cp.Empty() {
+		return false
+	}
+	logger.Info("resuming from checkpoint", "interrupted_run", cp.RunID, "packages", len(cp.Packages), "written_at", cp.WrittenAt)
+	for _, name := range cp.Packages {
+		sched.Add(ctx, scheduler.Trigger{Reason: "checkpoint", Package: name, Priority: scheduler.PriorityResume, Urgent: true})
+	}
+	if cp.Full {
+		sched.Add(ctx, scheduler.Trigger{Reason: "checkpoint", Full: true, Urgent: true})
+	}
+	return true
+}
+
+// saveCheckpoint records the packages left unprocessed at shutdown: those an
+// interrupted or failed run did not finish and those still waiting for a run.
+// Without leftovers the checkpoint is removed.
+func saveCheckpoint(path, runID string, sched *scheduler.Scheduler, state *indexState, logger *slog.Logger) {
+	if path == "" {
+		return
+	}
+	names := make(map[string]scheduler.Priority, len(state.pending))
+	for name := range state.pending {
+		names[name] = scheduler.PriorityResume
+	}
+	cp := &checkpoint.Checkpoint{RunID: runID}
+	if batch, ok := sched.Flush(); ok {
+		cp.Full = batch.Full
+		for name, p := range batch.Packages {
+			if _, ok := names[name]; !ok {
+				names[name] = p
+			}
+		}
+	}
+	cp.Packages = scheduler.Order(names)
+	if cp.Empty() {
+		if err := checkpoint.Remove(path); err != nil {
+			logger.Warn("remove checkpoint", "error", err)
+		}
+		return
+	}
+	if err := checkpoint.Save(path, cp); err != nil {
+		logger.Error("save checkpoint", "error", err)
+		return
+	}
+	logger.Info("saved checkpoint", "path", path, "packages", len(cp.Packages), "full", cp.Full, "interrupted_run", runID)
+}
 
-	// main loop: debounce triggers
+// newElector sets up leader election from VRCWIKI_LEADER_ELECTION:
+//   - "none" (default): no election, this replica always writes (nil Elector)
+//   - "file": flock on VRCWIKI_LEADER_LOCK_FILE, for replicas on one host
+//   - "wiki": a lease stored on the page VRCWIKI_LEADER_LOCK_PAGE
+//   - "kubernetes": the Lease VRCWIKI_LEADER_LEASE_NAME in
+//     VRCWIKI_LEADER_LEASE_NAMESPACE (default: the pod's namespace)
+//
+// VRCWIKI_LEADER_IDENTITY, VRCWIKI_LEADER_TTL and VRCWIKI_LEADER_RENEW_INTERVAL
+// override the defaults. onElected is called whenever this replica becomes
+// the leader.
+func newElector(store pagestore.PageStore, logger *slog.Logger, onElected func()) (*leader.Elector, error) {
+	ttl, renew := leader.DefaultTTL, leader.DefaultRenewInterval
+	var lock leader.Lock
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_ELECTION"))); mode {
+	case "", "none":
+		return nil, nil
+	case "file":
+		path := strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_LOCK_FILE"))
+		if path == "" {
+			return nil, errors.New("VRCWIKI_LEADER_LOCK_FILE is required for file leader election")
+		}
+		lock = leader.NewFileLock(path)
+	case "wiki":
+		title := strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_LOCK_PAGE"))
+		if title == "" {
+			title = "Project:VRCWiki connector/Leader lock"
+		}
+		lock = leader.NewPageLock(store, title)
+		// every renewal is an edit; keep the page history short
+		ttl, renew = 10*time.Minute, 3*time.Minute
+	case "kubernetes":
+		name := strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_LEASE_NAME"))
+		if name == "" {
+			name = "vrcwiki-connector"
+		}
+		lease, err := leader.NewLeaseLock(strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_LEASE_NAMESPACE")), name)
+		if err != nil {
+			return nil, err
+		}
+		lock = lease
+	default:
+		return nil, fmt.Errorf("unknown leader election mode %q", mode)
+	}
+	ttl, err := durationEnv("VRCWIKI_LEADER_TTL", ttl)
+	if err != nil {
+		return nil, err
+	}
+	renew, err = durationEnv("VRCWIKI_LEADER_RENEW_INTERVAL", min(renew, ttl/3))
+	if err != nil {
+		return nil, err
+	}
+	return leader.NewElector(lock, leader.Config{
+		Identity:         strings.TrimSpace(os.Getenv("VRCWIKI_LEADER_IDENTITY")),
+		TTL:              ttl,
+		RenewInterval:    renew,
+		OnStartedLeading: onElected,
+		Logger:           logger,
+	})
+}
+
+// loadSourceConfigs reads VRCWIKI_SOURCES, a JSON array of sources.Config in
+// order of precedence. Without it the connector follows the local VPMM instance.
+func loadSourceConfigs() ([]sources.Config, error) {
+	raw := strings.TrimSpace(os.Getenv("VRCWIKI_SOURCES"))
+	if raw == "" {
+		return []sources.Config{{Name: "vpmm", Kind: sources.KindVPMM, URL: "http://api:8080"}}, nil
+	}
+	return sources.ParseConfigs(raw)
+}
+
+// durationEnv parses the duration in the environment variable name, or
+// returns def when it is unset.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", name, raw)
+	}
+	return d, nil
+}
+
+// loadRetryPolicy reads VRCWIKI_RETRY_MAX_ATTEMPTS (1 disables retries),
+// VRCWIKI_RETRY_MIN_DELAY and VRCWIKI_RETRY_MAX_DELAY.
+func loadRetryPolicy() (retry.Policy, error) {
+	var p retry.Policy
+	var err error
+	if p.MaxAttempts, err = intEnv("VRCWIKI_RETRY_MAX_ATTEMPTS", retry.DefaultMaxAttempts, 1); err != nil {
+		return retry.Policy{}, err
+	}
+	if p.MinDelay, err = durationEnv("VRCWIKI_RETRY_MIN_DELAY", retry.DefaultMinDelay); err != nil {
+		return retry.Policy{}, err
+	}
+	if p.MaxDelay, err = durationEnv("VRCWIKI_RETRY_MAX_DELAY", retry.DefaultMaxDelay); err != nil {
+		return retry.Policy{}, err
+	}
+	return p, nil
+}
+
+// loadBreakerConfig reads VRCWIKI_BREAKER_THRESHOLD (consecutive failures
+// that pause syncing), VRCWIKI_BREAKER_COOLDOWN and
+// VRCWIKI_BREAKER_MAX_COOLDOWN.
+func loadBreakerConfig() (breaker.Config, error) {
+	var cfg breaker.Config
+	var err error
+	if cfg.Threshold, err = intEnv("VRCWIKI_BREAKER_THRESHOLD", breaker.DefaultThreshold, 1); err != nil {
+		return breaker.Config{}, err
+	}
+	if cfg.Cooldown, err = durationEnv("VRCWIKI_BREAKER_COOLDOWN", breaker.DefaultCooldown); err != nil {
+		return breaker.Config{}, err
+	}
+	if cfg.MaxCooldown, err = durationEnv("VRCWIKI_BREAKER_MAX_COOLDOWN", breaker.DefaultMaxCooldown); err != nil {
+		return breaker.Config{}, err
+	}
+	return cfg, nil
+}
+
+// configureSyncer applies the package filters and the dry-run mode to syncer.
+// VRCWIKI_INCLUDE_PACKAGES, VRCWIKI_EXCLUDE_PACKAGES, VRCWIKI_INCLUDE_AUTHORS,
+// VRCWIKI_EXCLUDE_AUTHORS, VRCWIKI_INCLUDE_REPOSITORIES and
+// VRCWIKI_EXCLUDE_REPOSITORIES are comma-separated globs (see
+// wikisync.Filter). VRCWIKI_DRY_RUN only logs the writes a sync would make;
+// VRCWIKI_CANARY_PACKAGES implies it but still writes the pages of the
+// packages matching its globs.
+func configureSyncer(syncer *wikisync.Syncer, logger *slog.Logger) (*wikisync.Syncer, error) {
+	f := wikisync.Filter{
+		Include:             listEnv("VRCWIKI_INCLUDE_PACKAGES"),
+		Exclude:             listEnv("VRCWIKI_EXCLUDE_PACKAGES"),
+		IncludeAuthors:      listEnv("VRCWIKI_INCLUDE_AUTHORS"),
+		ExcludeAuthors:      listEnv("VRCWIKI_EXCLUDE_AUTHORS"),
+		IncludeRepositories: listEnv("VRCWIKI_INCLUDE_REPOSITORIES"),
+		ExcludeRepositories: listEnv("VRCWIKI_EXCLUDE_REPOSITORIES"),
+	}
+	if !f.IsZero() {
+		logger.Info("package filter configured", "filter", f)
+		syncer = syncer.WithFilter(f)
+	}
+	dryRun := false
+	if raw := strings.TrimSpace(os.Getenv("VRCWIKI_DRY_RUN")); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("VRCWIKI_DRY_RUN: invalid boolean %q", raw)
+		}
+	}
+	canary := listEnv("VRCWIKI_CANARY_PACKAGES")
+	switch {
+	case len(canary) > 0:
+		logger.Warn("canary mode: only the canary packages are written, all other writes are logged", "canary", canary)
+		syncer = syncer.WithDryRun(canary...)
+	case dryRun:
+		logger.Warn("dry run: wiki writes are only logged")
+		syncer = syncer.WithDryRun()
+	}
+	return syncer, nil
+}
+
+// listEnv splits the comma-separated environment variable name, dropping
+// empty items.
+func listEnv(name string) []string {
+	var items []string
+	for item := range strings.SplitSeq(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadSafetyGuard reads the per-run limits VRCWIKI_MAX_EDITS,
+// VRCWIKI_MAX_EDITS_PERCENT, VRCWIKI_MAX_DELETIONS,
+// VRCWIKI_MAX_DELETIONS_PERCENT and VRCWIKI_MAX_INDEX_DROP_PERCENT (0
+// disables a limit), VRCWIKI_SAFETY_STATE_FILE, where a trip and the index
+// sizes are persisted (safety.json in the state directory by default), and
+// VRCWIKI_SAFETY_OVERRIDE, which clears a trip and lifts the limits for one
+// run.
+func loadSafetyGuard(logger *slog.Logger) (*safety.Guard, error) {
+	var l safety.Limits
+	var err error
+	if l.MaxEdits, err = intEnv("VRCWIKI_MAX_EDITS", safety.DefaultMaxEdits, 0); err != nil {
+		return nil, err
+	}
+	if l.MaxEditsPercent, err = intEnv("VRCWIKI_MAX_EDITS_PERCENT", safety.DefaultMaxEditsPercent, 0); err != nil {
+		return nil, err
+	}
+	if l.MaxDeletions, err = intEnv("VRCWIKI_MAX_DELETIONS", safety.DefaultMaxDeletions, 0); err != nil {
+		return nil, err
+	}
+	if l.MaxDeletionsPercent, err = intEnv("VRCWIKI_MAX_DELETIONS_PERCENT", safety.DefaultMaxDeletionsPercent, 0); err != nil {
+		return nil, err
+	}
+	if l.MaxIndexDropPercent, err = intEnv("VRCWIKI_MAX_INDEX_DROP_PERCENT", safety.DefaultMaxIndexDropPercent, 0); err != nil {
+		return nil, err
+	}
+	override := false
+	if raw := strings.TrimSpace(os.Getenv("VRCWIKI_SAFETY_OVERRIDE")); raw != "" {
+		if override, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("VRCWIKI_SAFETY_OVERRIDE: invalid boolean %q", raw)
+		}
+	}
+	path := strings.TrimSpace(os.Getenv("VRCWIKI_SAFETY_STATE_FILE"))
+	if path == "" {
+		if dir, err := stateDir(); err != nil {
+			logger.Warn("safety state not persisted", "error", err)
+		} else {
+			path = filepath.Join(dir, "safety.json")
+		}
+	}
+	return safety.NewGuard(l, path, override, logger)
+}
+
+// intEnv parses the integer of at least floor in the environment variable
+// name, or returns def if it is unset.
+func intEnv(name string, def, floor int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < floor {
+		return 0, fmt.Errorf("%s: invalid number %q", name, raw)
+	}
+	return n, nil
+}
+
+// sourceStream returns the SSE stream of a VPMM source. It reconnects with
+// jittered backoff when the stream fails or stays silent for idleTimeout. A
+// (re)connect triggers a sync to catch up on events missed while
+// disconnected. Unknown and malformed events are logged and counted in
+// counters.
+func sourceStream(ctx context.Context, src *sources.Source, sseURL string, sseClient *http.Client, idleTimeout time.Duration, sched *scheduler.Scheduler, counters *eventCounters, logger *slog.Logger) *apiclient.SSEStream {
+	logger = logger.With("source", src.Name)
+	var stream *apiclient.SSEStream
+	stream = apiclient.NewSSEStream(sseURL, sseClient, apiclient.SSEHandlers{
+		OnConnect: func() {
+			logger.Info("sse connected")
+			sched.Add(ctx, scheduler.Trigger{Reason: "stream.connected"})
+		},
+		OnDisconnect: func(err error, retryIn time.Duration) {
+			st := stream.State()
+			if st.Failures == 0 {
+				logger.Warn("sse disconnected, polling until it recovers", "error", err, "retry_in", retryIn, "last_activity", st.LastActivity)
+				return
+			}
+			logger.Warn("sse error", "error", err, "retry_in", retryIn, "failures", st.Failures)
+		},
+		OnPackageAdded: func(ctx context.Context, event apiclient.PackageAddedEvent) {
+			sched.Add(ctx, packageTrigger(ctx, apiclient.EventPackageAdded, event.Identifier))
+		},
+		OnPackageUpdated: func(ctx context.Context, event apiclient.PackageUpdatedEvent) {
+			sched.Add(ctx, packageTrigger(ctx, apiclient.EventPackageUpdated, event.Identifier))
+		},
+		OnPackageRemoved: func(ctx context.Context, event apiclient.PackageRemovedEvent) {
+			sched.Add(ctx, scheduler.Trigger{Reason: apiclient.EventPackageRemoved, Package: event.Identifier.Name, Span: trace.SpanContextFromContext(ctx)})
+		},
+		OnPackageYanked: func(ctx context.Context, event apiclient.PackageYankedEvent) {
+			logger.InfoContext(ctx, "package version yanked", "package", event.Identifier.Name, "version", event.Identifier.Version, "reason", event.Reason)
+			sched.Add(ctx, scheduler.Trigger{Reason: apiclient.EventPackageYanked, Package: event.Identifier.Name, Span: trace.SpanContextFromContext(ctx)})
+		},
+		OnPackageDeprecated: func(ctx context.Context, event apiclient.PackageDeprecatedEvent) {
+			logger.InfoContext(ctx, "package deprecated", "package", event.Identifier.Name, "version", event.Identifier.Version, "message", event.Message)
+			sched.Add(ctx, scheduler.Trigger{Reason: apiclient.EventPackageDeprecated, Package: event.Identifier.Name, Span: trace.SpanContextFromContext(ctx)})
+		},
+		OnRepositoryUpdated: func(ctx context.Context, event apiclient.RepositoryUpdatedEvent) {
+			sched.Add(ctx, scheduler.Trigger{Reason: apiclient.EventRepositoryUpdated, Span: trace.SpanContextFromContext(ctx)})
+		},
+		OnResync: func(ctx context.Context, event apiclient.ResyncEvent) {
+			// the server lost track of what it sent; reconcile everything now
+			logger.InfoContext(ctx, "resync requested by source", "reason", event.Reason)
+			sched.Add(ctx, scheduler.Trigger{Reason: apiclient.EventResync, Full: true, Urgent: true, Span: trace.SpanContextFromContext(ctx)})
+		},
+		OnUnknown: func(ctx context.Context, name string, raw json.RawMessage) {
+			n := counters.unknown.Add(1)
+			logger.WarnContext(ctx, "sse: unknown event ignored", "event", name, "bytes", len(raw), "unknown_total", n)
+		},
+		OnDecodeError: func(ctx context.Context, name string, raw json.RawMessage, err error) {
+			n := counters.malformed.Add(1)
+			logger.WarnContext(ctx, "sse: malformed event ignored", "event", name, "bytes", len(raw), "error", err, "malformed_total", n)
+		},
+		Logger: logger,
+	}, apiclient.SSEOptions{IdleTimeout: idleTimeout})
+	return stream
+}
+
+// packageTrigger asks for a sync of the package in id. Stable releases get
+// high priority so they reach the wiki ahead of pre-releases.
+func packageTrigger(ctx context.Context, event string, id apiclient.PackageIdentifier) scheduler.Trigger {
+	t := scheduler.Trigger{Reason: event, Package: id.Name, Span: trace.SpanContextFromContext(ctx)}
+	if isStableVersion(id.Version) {
+		t.Priority = scheduler.PriorityHigh
+	}
+	return t
+}
+
+// isStableVersion reports whether v is a valid semver without pre-release.
+func isStableVersion(v string) bool {
+	sv, err := semver.NewVersion(strings.TrimSpace(v))
+	return err == nil && sv.Prerelease() == ""
+}
+
+// pollSource triggers a sync every interval while active reports true (always
+// when active is nil); the conditional fetch makes unchanged listings cheap.
+func pollSource(ctx context.Context, src *sources.Source, interval time.Duration, active func() bool, sched *scheduler.Scheduler) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Println("shutting down")
 			return
-		case ev, ok := <-events:
-			if !ok {
-				// channel closed; terminate gracefully allowing any pending sync
+		case <-ticker.C:
+			if active != nil && !active() {
 				continue
 			}
-			switch ev.Event {
-			case "package.added", "package.updated", "package.removed":
-				resetTimer()
-			}
-		case <-syncTimer.C:
-			// execute full sync
-			logger.Println("running wiki full sync")
-			runFullSync(ctx, cli, wikiClient, logger)
+			sched.Add(ctx, scheduler.Trigger{Reason: "source.poll"})
+		}
+	}
+}
+
+// newLogger builds the process-wide logger from VRCWIKI_LOG_LEVEL (debug,
+// info, warn, error) and VRCWIKI_LOG_FORMAT (json or text). All output goes
+// through redactor.
+func newLogger(redactor *secrets.Redactor) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(os.Getenv("VRCWIKI_LOG_LEVEL"))
+	if err != nil {
+		return nil, err
+	}
+	h, err := logging.NewHandler(os.Stdout, level, os.Getenv("VRCWIKI_LOG_FORMAT"))
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(redactor.Handler(h)).With("service", "vrcwiki-connector"), nil
+}
+
+// fatal logs err and exits.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// loadWikiConfig reads the wiki connection settings from the environment.
+// Credentials may also be supplied as files via the matching *_FILE variable.
+func loadWikiConfig() (mw.WikiConfig, error) {
+	config := mw.WikiConfig{
+		URL:    os.Getenv("VRCWIKI_API_URL"),
+		Header: os.Getenv("VRCWIKI_AUTHORIZATION_HEADER"),
+		Auth:   os.Getenv("VRCWIKI_AUTH_METHOD"),
+	}
+	fields := []struct {
+		env string
+		dst *string
+	}{
+		{"VRCWIKI_USERNAME", &config.Username},
+		{"VRCWIKI_PASSWORD", &config.Password},
+		{"VRCWIKI_AUTHORIZATION_VALUE", &config.HeaderVal},
+		{"VRCWIKI_OAUTH2_TOKEN", &config.OAuth2Token},
+		{"VRCWIKI_OAUTH1_CONSUMER_KEY", &config.OAuth1.ConsumerKey},
+		{"VRCWIKI_OAUTH1_CONSUMER_SECRET", &config.OAuth1.ConsumerSecret},
+		{"VRCWIKI_OAUTH1_ACCESS_TOKEN", &config.OAuth1.AccessToken},
+		{"VRCWIKI_OAUTH1_ACCESS_SECRET", &config.OAuth1.AccessSecret},
+	}
+	for _, f := range fields {
+		v, err := secrets.Lookup(f.env)
+		if err != nil {
+			return mw.WikiConfig{}, err
 		}
+		*f.dst = v
 	}
+	return config, nil
 }
 
-// runFullSync orchestrates a complete wiki sync using the new client helpers.
-func runFullSync(ctx context.Context, cli *apiclient.ClientWithResponses, wikiClient *mw.MediaWikiClient, logger *log.Logger) {
-	resp, err := cli.GetIndexWithResponse(ctx, nil)
+// wikiSecrets lists the values of config that must never appear in logs.
+func wikiSecrets(config mw.WikiConfig) []string {
+	return []string{
+		config.Password,
+		config.HeaderVal,
+		config.OAuth2Token,
+		config.OAuth1.ConsumerSecret,
+		config.OAuth1.AccessToken,
+		config.OAuth1.AccessSecret,
+	}
+}
+
+// reloadWikiCredentials re-reads the credentials and hands them to the wiki
+// client. The previous secrets stay redacted until the new ones are in use.
+func reloadWikiCredentials(ctx context.Context, wikiClient *mw.MediaWikiClient, current *mw.WikiConfig, redactor *secrets.Redactor, logger *slog.Logger) {
+	config, err := loadWikiConfig()
 	if err != nil {
-		logger.Printf("full sync: get index: %v", err)
+		logger.Error("reload credentials", "error", err)
+		return
+	}
+	if wikiClient == nil {
+		logger.Info("reload credentials: no wiki client configured, nothing to do")
 		return
 	}
+	redactor.Set(append(wikiSecrets(*current), wikiSecrets(config)...)...)
+	if err := wikiClient.UpdateCredentials(ctx, config); err != nil {
+		logger.Error("reload credentials", "error", err)
+		return
+	}
+	if _, err := wikiClient.VerifyRights(ctx, mw.RequiredRights...); err != nil {
+		logger.Error("reload credentials", "error", err)
+	}
+	redactor.Set(wikiSecrets(config)...)
+	config.Logger = current.Logger
+	*current = config
+	logger.Info("reloaded wiki credentials")
+}
 
-	// OpenAPI currently does not describe the /index.json 200 payload shape, so the
-	// generated client exposes it as raw bytes.
-	if resp.StatusCode() != http.StatusOK {
-		// Prefer structured error payloads when available.
+// sourceState is the last listing fetched from one source.
+type sourceState struct {
+	listing    *apiclient.RepositoryListing
+	validators apiclient.IndexValidators
+}
+
+// indexState carries the last processed listings between sync runs, so
+// unchanged sources are skipped and changes only touch affected packages.
+type indexState struct {
+	// sources is keyed by source name
+	sources map[string]*sourceState
+	// listing is the merged listing of the last run
+	listing *apiclient.RepositoryListing
+	// repos maps the packages of listing to the repository they came from
+	repos map[string]wikisync.Repository
+	// pending holds packages whose last sync failed; they are retried next run
+	pending map[string]struct{}
+}
+
+// fetchSources fetches every source conditionally and merges the listings by
+// precedence. Sources that are unchanged or fail keep their previous listing.
+// counts maps the sources that answered, changed or not, to the package
+// count of their listing. changed reports whether any source delivered a new
+// listing.
+func (st *indexState) fetchSources(ctx context.Context, srcs []*sources.Source, logger *slog.Logger) (merged *apiclient.RepositoryListing, repos map[string]wikisync.Repository, counts map[string]int, changed bool) {
+	listings := make([]*apiclient.RepositoryListing, len(srcs))
+	counts = make(map[string]int, len(srcs))
+	for i, src := range srcs {
+		ss := st.sources[src.Name]
+		if ss == nil {
+			ss = &sourceState{}
+			st.sources[src.Name] = ss
+		}
+		listing, validators, err := src.Fetch(ctx, ss.validators)
 		switch {
-		case resp.ApplicationproblemJSON401 != nil:
-			logger.Printf("full sync: get index: unauthorized: %s", safeErrDetail(resp.ApplicationproblemJSON401))
-		case resp.ApplicationproblemJSON422 != nil:
-			logger.Printf("full sync: get index: unprocessable: %s", safeErrDetail(resp.ApplicationproblemJSON422))
-		case resp.ApplicationproblemJSON500 != nil:
-			logger.Printf("full sync: get index: server error: %s", safeErrDetail(resp.ApplicationproblemJSON500))
+		case errors.Is(err, apiclient.ErrIndexNotModified):
+			logger.DebugContext(ctx, "source not modified", "source", src.Name)
+			counts[src.Name] = len(ss.listing.Packages)
+		case err != nil:
+			logger.ErrorContext(ctx, "fetch source", "source", src.Name, "error", err)
 		default:
-			logger.Printf("full sync: get index: unexpected status: %s", resp.Status())
+			logger.DebugContext(ctx, "fetched repository listing", "source", src.Name, "repository", listing.ID, "packages", len(listing.Packages))
+			ss.listing, ss.validators = listing, validators
+			counts[src.Name] = len(listing.Packages)
+			changed = true
 		}
-		return
+		listings[i] = ss.listing
 	}
-	if len(resp.Body) == 0 {
-		logger.Printf("full sync: get index: empty response body")
-		return
+
+	merged, origins := sources.Merge(listings)
+	repos = make(map[string]wikisync.Repository, len(origins))
+	for name, i := range origins {
+		l := listings[i]
+		// the fetch URL may be internal to the deployment; only publish the
+		// URL the listing declares
+		repos[name] = wikisync.Repository{ID: l.ID, Name: l.Name, URL: l.URL}
 	}
+	return merged, repos, counts, changed
+}
 
-	var idx vccIndex
-	if err := json.Unmarshal(resp.Body, &idx); err != nil {
-		logger.Printf("full sync: get index: decode json: %v", err)
-		return
+// diff compares listing with the one of the last run. The merged listing has
+// no name or url of its own, so packages whose repository differs from the
+// last run are marked RepositoryChanged here.
+func (st *indexState) diff(listing *apiclient.RepositoryListing, repos map[string]wikisync.Repository) apiclient.ListingDiff {
+	diff := apiclient.DiffListings(st.listing, listing)
+	for name, repo := range repos {
+		if old, ok := st.repos[name]; ok && old != repo {
+			d := diff[name]
+			d.RepositoryChanged = true
+			diff[name] = d
+		}
 	}
+	return diff
+}
 
-	pkgs := flattenIndexPackages(&idx)
+// affectedPackages returns the packages a run has to process given the diff
+// against the last run, or nil when every package must be processed (first
+// run). dirty packages named by triggers are always included.
+func (st *indexState) affectedPackages(diff apiclient.ListingDiff, dirty map[string]scheduler.Priority) map[string]struct{} {
+	if st.listing == nil {
+		return nil
+	}
+	affected := make(map[string]struct{}, len(st.pending)+len(dirty)+len(diff))
+	for name := range st.pending {
+		affected[name] = struct{}{}
+	}
+	for name := range dirty {
+		affected[name] = struct{}{}
+	}
+	for name := range diff {
+		affected[name] = struct{}{}
+	}
+	return affected
+}
+
+// packagePriorities ranks the packages of a run. Stable releases, whether
+// announced by an event or found in the diff, are processed first.
+func packagePriorities(names map[string]struct{}, diff apiclient.ListingDiff, dirty map[string]scheduler.Priority) map[string]scheduler.Priority {
+	prio := make(map[string]scheduler.Priority, len(names))
+	for name := range names {
+		p := dirty[name]
+		if d, ok := diff[name]; ok && p < scheduler.PriorityHigh {
+			for _, v := range slices.Concat(d.AddedVersions, d.ChangedVersions) {
+				if isStableVersion(v) {
+					p = scheduler.PriorityHigh
+					break
+				}
+			}
+		}
+		prio[name] = p
+	}
+	return prio
+}
+
+// runFullSync fetches all sources and syncs the affected packages to the
+// wiki, stable releases first. With batch.Full set, every package is
+// processed even if nothing changed. Once stop reports true no further page
+// is written; the package being synced and the unprocessed ones are left in
+// state.pending and interrupted is true. The wiki config page is read first: it may pause the
+// run, request a full one, exclude packages and pin fields. The run is
+// aborted before any write if no source returned a listing or the index
+// shrank abnormally, and its writes are limited by guard.
+func runFullSync(ctx context.Context, stop func() bool, srcs []*sources.Source, syncer *wikisync.Syncer, state *indexState, batch scheduler.Batch, botCfg *botconfig.Source, guard *safety.Guard, maintenance maintenanceConfig, logger *slog.Logger) (interrupted bool) {
+	// the override covers this run only, however it ends
+	defer guard.EndRun()
+	update := botCfg.Load(ctx)
+	if update.Paused {
+		logger.InfoContext(ctx, "full sync: paused by the wiki config page", "reason", update.PauseReason)
+		return true
+	}
+	if update.SyncRequested || update.Resumed {
+		batch.Full = true
+	}
+	syncer = applyBotConfig(syncer, update.Config)
+
+	listing, repos, counts, changed := state.fetchSources(ctx, srcs, logger)
+	if len(counts) == 0 {
+		// an empty listing would look like every package was removed
+		logger.ErrorContext(ctx, "full sync: no source returned a listing, skipping run")
+		return true
+	}
+	if !batch.Full && !changed && len(state.pending) == 0 && len(batch.Packages) == 0 {
+		logger.InfoContext(ctx, "full sync: no source changed, nothing to do")
+		return false
+	}
+	syncer = syncer.WithRepositories(repos)
+	diff := state.diff(listing, repos)
+	var affected map[string]struct{}
+	if !batch.Full {
+		affected = state.affectedPackages(diff, batch.Packages)
+	}
+	if affected != nil {
+		logger.InfoContext(ctx, "full sync: processing changed packages", "packages", len(affected), "retried", len(state.pending))
+	}
+
+	pkgs := flattenIndexPackages(listing)
 
 	// Build versions map and compute latest/stable/unstable
-	allVersionsMap := mw.BuildAllVersionsMapFromAPI(pkgs)
-	latestMap, stableMap, unstableMap := mw.ComputeLatestStableUnstable(allVersionsMap)
+	allVersionsMap := wikisync.BuildAllVersionsMapFromAPI(pkgs)
+	latestMap, stableMap, unstableMap := wikisync.ComputeLatestStableUnstable(allVersionsMap)
 
 	// Scan wiki
-	packagePages, wikiVersionsMap, err := wikiClient.ScanVpmPages()
+	packagePages, wikiVersionsMap, err := syncer.ScanVpmPages(ctx)
 	if err != nil {
-		logger.Printf("full sync: scan wiki: %v", err)
+		logger.ErrorContext(ctx, "full sync: scan wiki", "error", err)
 		// continue with what we have
 		packagePages = map[string][]string{}
 		wikiVersionsMap = map[string][]string{}
 	}
 
-	// Union of package names from API and wiki
+	// guardrails: compare the listings of the sources that answered to
+	// their last accepted size and cap the writes of this run
+	if err := guard.CheckIndex(ctx, counts); err != nil {
+		return true
+	}
+	managed := 0
+	for _, titles := range packagePages {
+		managed += len(titles)
+	}
+	budget := guard.Budget(syncer.Store(), managed)
+	syncer = syncer.WithStore(stoppableStore{PageStore: budget, stop: stop})
+
+	// Union of package names from API and wiki, restricted to affected packages
 	nameSet := make(map[string]struct{})
 	for name := range allVersionsMap {
 		nameSet[name] = struct{}{}
@@ -188,66 +1093,279 @@ func runFullSync(ctx context.Context, cli *apiclient.ClientWithResponses, wikiCl
 	for name := range packagePages {
 		nameSet[name] = struct{}{}
 	}
-
-	// For each package, update latest/stable/unstable and specific versions
-	for name := range nameSet {
+	if affected != nil {
+		for name := range nameSet {
+			if _, ok := affected[name]; !ok {
+				delete(nameSet, name)
+			}
+		}
+	}
+	// the glob filters and the config page's exclusions also keep packages
+	// out of the version summary and the maintenance report
+	selects := func(name string) bool {
+		var latest *apiclient.Package
 		if v, ok := latestMap[name]; ok {
-			if err := wikiClient.UpdateLatestVersionPages(v); err != nil {
-				logger.Printf("full sync: update latest for %s: %v", name, err)
+			latest = &v
+		}
+		return syncer.Selects(name, latest)
+	}
+	filtered := 0
+	for name := range nameSet {
+		if !selects(name) {
+			delete(nameSet, name)
+			filtered++
+		}
+	}
+	if filtered > 0 {
+		logger.InfoContext(ctx, "full sync: packages filtered out", "filtered", filtered, "remaining", len(nameSet))
+	}
+	failed := make(map[string]struct{})
+
+	// reportError logs a failed page update; writes refused because the run
+	// is stopping are expected
+	reportError := func(ctx context.Context, msg string, err error, args ...any) {
+		level := slog.LevelError
+		if errors.Is(err, errSyncStopped) {
+			level = slog.LevelDebug
+		}
+		logger.Log(ctx, level, msg, append(args, "error", err)...)
+	}
+
+	// syncPackage updates latest/stable/unstable and the specific version
+	// pages of one package and reports whether everything succeeded
+	syncPackage := func(ctx context.Context, name string) (ok bool) {
+		ctx, span := tracer.Start(logging.With(ctx, logging.KeyPackage, name), "sync.package",
+			trace.WithAttributes(attribute.String("vpm.package", name)))
+		defer span.End()
+		ok = true
+		if v, found := latestMap[name]; found {
+			if err := syncer.UpdateLatestVersionPages(ctx, v); err != nil {
+				reportError(ctx, "full sync: update latest", err)
+				ok = false
 			}
 		}
-		if v, ok := stableMap[name]; ok {
-			if err := wikiClient.UpdateLatestStableVersionPages(v); err != nil {
-				logger.Printf("full sync: update latest stable for %s: %v", name, err)
+		if v, found := stableMap[name]; found {
+			if err := syncer.UpdateLatestStableVersionPages(ctx, v); err != nil {
+				reportError(ctx, "full sync: update latest stable", err)
+				ok = false
 			}
 		}
-		if v, ok := unstableMap[name]; ok {
-			if err := wikiClient.UpdateLatestUnstableVersionPages(v); err != nil {
-				logger.Printf("full sync: update latest unstable for %s: %v", name, err)
+		if v, found := unstableMap[name]; found {
+			if err := syncer.UpdateLatestUnstableVersionPages(ctx, v); err != nil {
+				reportError(ctx, "full sync: update latest unstable", err)
+				ok = false
 			}
 		}
 
 		// known versions for this package
 		known := make(map[string]apiclient.Package)
-		if vs, ok := allVersionsMap[name]; ok {
+		if vs, found := allVersionsMap[name]; found {
 			for _, pv := range vs {
 				known[pv.Version] = pv
 			}
 		}
 		// process version pages detected on wiki
-		if versions, ok := wikiVersionsMap[name]; ok {
+		if versions, found := wikiVersionsMap[name]; found {
 			for _, tag := range versions {
-				if err := wikiClient.ProcessSpecificVersionPage(name, tag, known); err != nil {
-					logger.Printf("full sync: process version %s/%s: %v", name, tag, err)
+				if err := syncer.ProcessSpecificVersionPage(ctx, name, tag, known); err != nil {
+					reportError(ctx, "full sync: process version", err, "version", tag)
+					ok = false
 				}
 			}
 		}
+		span.SetAttributes(attribute.Bool("sync.failed", !ok))
+		return ok
+	}
+
+	// Process packages by priority. A package that fails is requeued once at
+	// the end, giving a flaky wiki or API time to recover before its retry.
+	queue := scheduler.Order(packagePriorities(nameSet, diff, batch.Packages))
+	requeued := make(map[string]bool)
+	// interrupt leaves queue[i:] for the next run
+	interrupt := func(i int) {
+		logger.WarnContext(ctx, "full sync: interrupted", "remaining", len(queue)-i)
+		for _, rest := range queue[i:] {
+			failed[rest] = struct{}{}
+		}
+		interrupted = true
+	}
+	for i := 0; i < len(queue); i++ {
+		name := queue[i]
+		if stop() {
+			interrupt(i)
+			break
+		}
+		if syncPackage(ctx, name) {
+			continue
+		}
+		if stop() {
+			// stopped between the package's page writes
+			interrupt(i)
+			break
+		}
+		if !requeued[name] {
+			requeued[name] = true
+			queue = append(queue, name)
+			logger.WarnContext(logging.With(ctx, logging.KeyPackage, name), "full sync: package failed, requeued at the end")
+			continue
+		}
+		failed[name] = struct{}{}
+	}
+	if len(requeued) > 0 {
+		logger.InfoContext(ctx, "full sync: requeued packages done", "requeued", len(requeued), "failed", len(failed))
+	}
+
+	edits, deletions := budget.Counts()
+	logger.InfoContext(ctx, "full sync: pages written", "edits", edits, "deletions", deletions, "managed_pages", managed)
+
+	// remember what was processed; failed packages are retried on the next run
+	state.listing, state.repos = listing, repos
+	state.pending = failed
+	if interrupted {
+		return true
 	}
 
 	// Generate and write the version summary table
-	table, err := mw.GenerateVersionSummaryWikiTableWithWikiVersions(wikiVersionsMap, allVersionsMap)
+	summaryVersions := maps.Clone(allVersionsMap)
+	maps.DeleteFunc(summaryVersions, func(name string, _ []apiclient.Package) bool { return !selects(name) })
+	summaryWiki := maps.Clone(wikiVersionsMap)
+	maps.DeleteFunc(summaryWiki, func(name string, _ []string) bool { return !selects(name) })
+	table, err := wikisync.GenerateVersionSummaryWikiTableWithWikiVersions(summaryWiki, summaryVersions)
 	if err != nil {
-		logger.Printf("full sync: generate version table: %v", err)
-		return
+		logger.ErrorContext(ctx, "full sync: generate version table", "error", err)
+		return false
 	}
-	if err := wikiClient.EditPage("Template:VPM/Version summary", table, true); err != nil {
-		logger.Printf("full sync: update version summary page: %v", err)
+	if err := syncer.EditPage(ctx, wikisync.VersionSummaryPageTitle, table, true); err != nil {
+		reportError(ctx, "full sync: update version summary page", err)
 	}
+
+	writeMaintenanceReport(ctx, syncer, allVersionsMap, maintenance, logger)
+	return false
 }
 
-// vccIndex is the structure of `/index.json` (VPM/VCC spec listing).
-type vccIndex struct {
-	Packages map[string]vccIndexPackage `json:"packages"`
+// applyBotConfig adds the exclusions and pins of the wiki config page to
+// syncer.
+func applyBotConfig(syncer *wikisync.Syncer, cfg botconfig.Config) *wikisync.Syncer {
+	if len(cfg.Exclude) > 0 {
+		f := syncer.Filter()
+		f.Exclude = append(slices.Clone(f.Exclude), cfg.Exclude...)
+		syncer = syncer.WithFilter(f)
+	}
+	if len(cfg.Pins) > 0 {
+		syncer = syncer.WithPins(cfg.Pins)
+	}
+	return syncer
 }
 
-type vccIndexPackage struct {
-	Versions map[string]apiclient.Package `json:"versions"`
+// pollBotConfig reloads the wiki config page every interval while active
+// reports true, and schedules a full sync when the page requests one or
+// resumes the bot.
+func pollBotConfig(ctx context.Context, src *botconfig.Source, interval time.Duration, active func() bool, sched *scheduler.Scheduler) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !active() {
+			continue
+		}
+		switch update := src.Load(ctx); {
+		case update.Paused:
+		case update.SyncRequested:
+			sched.Add(ctx, scheduler.Trigger{Reason: "wiki.config.sync_request", Full: true, Urgent: true})
+		case update.Resumed:
+			sched.Add(ctx, scheduler.Trigger{Reason: "wiki.config.resumed", Full: true, Urgent: true})
+		}
+	}
+}
+
+// errSyncStopped is returned by stoppableStore for writes after the run was
+// stopped.
+var errSyncStopped = errors.New("sync stopped")
+
+// stoppableStore refuses writes once stop reports true, so a stopping run
+// ends between two page writes instead of finishing the current package.
+type stoppableStore struct {
+	pagestore.PageStore
+	stop func() bool
+}
+
+// Put implements pagestore.PageStore.
+func (s stoppableStore) Put(ctx context.Context, title, content string, opts pagestore.PutOptions) error {
+	if s.stop() {
+		return fmt.Errorf("edit %s: %w", title, errSyncStopped)
+	}
+	return s.PageStore.Put(ctx, title, content, opts)
+}
+
+// Delete implements pagestore.PageStore.
+func (s stoppableStore) Delete(ctx context.Context, title, reason string) error {
+	if s.stop() {
+		return fmt.Errorf("delete %s: %w", title, errSyncStopped)
+	}
+	return s.PageStore.Delete(ctx, title, reason)
+}
+
+// stopped reports whether stop is closed.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// maintenanceConfig controls where the maintenance (lint) report is published.
+// Both outputs are optional; the report is skipped when neither is set.
+type maintenanceConfig struct {
+	// Page is the wiki page title the report table is written to.
+	Page string
+	// JSONPath is a file path the report is written to as JSON; "-" means
+	// stdout.
+	JSONPath string
+}
+
+// writeMaintenanceReport lints all Template:VPM/* pages and publishes the result.
+func writeMaintenanceReport(ctx context.Context, syncer *wikisync.Syncer, allVersionsMap map[string][]apiclient.Package, cfg maintenanceConfig, logger *slog.Logger) {
+	if cfg.Page == "" && cfg.JSONPath == "" {
+		return
+	}
+	report, err := syncer.LintVpmPages(ctx, allVersionsMap, cfg.Page)
+	if err != nil {
+		logger.ErrorContext(ctx, "maintenance report: lint pages", "error", err)
+		return
+	}
+	if cfg.Page != "" {
+		if err := syncer.EditPage(ctx, cfg.Page, wikisync.RenderLintReportWikiTable(report), true); err != nil {
+			logger.ErrorContext(ctx, "maintenance report: update page", "page", cfg.Page, "error", err)
+		}
+	}
+	if cfg.JSONPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.ErrorContext(ctx, "maintenance report: encode json", "error", err)
+			return
+		}
+		data = append(data, '\n')
+		if cfg.JSONPath == "-" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = atomicfile.WriteFile(cfg.JSONPath, data, 0o644)
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "maintenance report: write json", "error", err)
+		}
+	}
 }
 
 // flattenIndexPackages converts an index response into a slice of packages sorted
 // by version descending per package so downstream helpers continue to see the
 // latest version first.
-func flattenIndexPackages(idx *vccIndex) []apiclient.Package {
+func flattenIndexPackages(idx *apiclient.RepositoryListing) []apiclient.Package {
 	if idx == nil || len(idx.Packages) == 0 {
 		return nil
 	}
@@ -269,46 +1387,8 @@ func flattenIndexPackages(idx *vccIndex) []apiclient.Package {
 		for _, pkg := range listPkg.Versions {
 			versions = append(versions, pkg)
 		}
-		sortPackagesByVersionDesc(versions)
+		wikisync.SortPackagesByVersionDesc(versions)
 		out = append(out, versions...)
 	}
 	return out
 }
-
-// sortPackagesByVersionDesc sorts packages in-place by semantic version
-// descending, falling back to string comparison when parsing fails.
-func sortPackagesByVersionDesc(pkgs []apiclient.Package) {
-	sort.SliceStable(pkgs, func(i, j int) bool {
-		left := strings.TrimSpace(pkgs[i].Version)
-		right := strings.TrimSpace(pkgs[j].Version)
-		vi, errI := semver.NewVersion(left)
-		vj, errJ := semver.NewVersion(right)
-
-		switch {
-		case errI == nil && errJ == nil:
-			return vi.GreaterThan(vj)
-		case errI == nil:
-			return true
-		case errJ == nil:
-			return false
-		default:
-			return left > right
-		}
-	})
-}
-
-func safeErrDetail(e *apiclient.ErrorModel) string {
-	if e == nil {
-		return ""
-	}
-	if e.Title != nil && e.Detail != nil {
-		return *e.Title + ": " + *e.Detail
-	}
-	if e.Detail != nil {
-		return *e.Detail
-	}
-	if e.Title != nil {
-		return *e.Title
-	}
-	return ""
-}
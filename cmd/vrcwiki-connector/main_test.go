@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/botconfig"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/safety"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/scheduler"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/testsupport"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+	mw "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/mediawiki"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/sources"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/wikisync"
+)
+
+const testPackage = "com.example.foo"
+
+// syncEnv runs runFullSync against a FakeWiki and a FakeVPMM.
+type syncEnv struct {
+	wiki   *testsupport.FakeWiki
+	vpmm   *testsupport.FakeVPMM
+	client *mw.MediaWikiClient
+	srcs   []*sources.Source
+	state  *indexState
+	guard  *safety.Guard
+	logger *slog.Logger
+	// maintenance is where runs publish the maintenance report
+	maintenance maintenanceConfig
+}
+
+func newSyncEnv(t *testing.T) *syncEnv {
+	t.Helper()
+	wiki := testsupport.NewFakeWiki()
+	t.Cleanup(wiki.Close)
+	wiki.AddUser("bot", "secret")
+	vpmm := testsupport.NewFakeVPMM()
+	t.Cleanup(vpmm.Close)
+
+	client, err := mw.NewMediaWikiClient(mw.WikiConfig{URL: wiki.URL(), Username: "bot", Password: "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Login(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	src, err := sources.New(sources.Config{Name: "vpmm", URL: vpmm.URL()}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.DiscardHandler)
+	guard, err := safety.NewGuard(safety.Limits{MaxIndexDropPercent: safety.DefaultMaxIndexDropPercent}, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &syncEnv{
+		wiki:   wiki,
+		vpmm:   vpmm,
+		client: client,
+		srcs:   []*sources.Source{src},
+		state:  &indexState{sources: map[string]*sourceState{}},
+		guard:  guard,
+		logger: logger,
+	}
+}
+
+// setVersions serves a listing with one package in the given versions.
+func (e *syncEnv) setVersions(t *testing.T, versions ...apiclient.Package) {
+	t.Helper()
+	e.setListing(t, "Example", versions...)
+}
+
+// setListing serves a listing named name with one package in the given
+// versions.
+func (e *syncEnv) setListing(t *testing.T, name string, versions ...apiclient.Package) {
+	t.Helper()
+	pkg := apiclient.ListingPackage{Versions: map[string]apiclient.Package{}}
+	for _, v := range versions {
+		v.Name = testPackage
+		pkg.Versions[v.Version] = v
+	}
+	listing := apiclient.RepositoryListing{
+		Name:     name,
+		ID:       "com.example",
+		URL:      "https://example.com/index.json",
+		Packages: map[string]apiclient.ListingPackage{testPackage: pkg},
+	}
+	if err := e.vpmm.SetIndex(listing); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// run runs one sync and returns the wiki writes it made.
+func (e *syncEnv) run(t *testing.T, batch scheduler.Batch) (interrupted bool, writes []testsupport.FakeWikiWrite) {
+	t.Helper()
+	before := len(e.wiki.Writes())
+	syncer := wikisync.NewSyncer(e.client, e.logger)
+	botCfg := botconfig.NewSource(e.client, botconfig.DefaultTitle, e.logger)
+	interrupted = runFullSync(context.Background(), func() bool { return false }, e.srcs, syncer, e.state, batch,
+		botCfg, e.guard, e.maintenance, e.logger)
+	return interrupted, e.wiki.Writes()[before:]
+}
+
+func (e *syncEnv) wantPage(t *testing.T, title, want string) {
+	t.Helper()
+	got, ok := e.wiki.Page(title)
+	switch {
+	case !ok:
+		t.Errorf("page %q missing", title)
+	case got != want:
+		t.Errorf("page %q = %q, want %q", title, got, want)
+	}
+}
+
+func editedTitles(writes []testsupport.FakeWikiWrite) map[string]bool {
+	titles := make(map[string]bool, len(writes))
+	for _, w := range writes {
+		titles[w.Title] = true
+	}
+	return titles
+}
+
+func TestRunFullSync(t *testing.T) {
+	e := newSyncEnv(t)
+	// the connector only maintains pages someone created on the wiki
+	e.wiki.SetPage("Template:VPM/com.example.foo/Latest_version", "0.9.0")
+	e.wiki.SetPage("Template:VPM/com.example.foo/Latest_stable_version", "0.9.0")
+	e.wiki.SetPage("Template:VPM/com.example.foo/1.0.0", "1.0.0")
+	e.wiki.SetPage("Template:VPM/com.example.foo/1.0.0/Repository", "Old name")
+	desc, license := "A package.", "MIT"
+	e.setVersions(t,
+		apiclient.Package{Version: "1.0.0", DisplayName: "Foo", Description: &desc, License: &license},
+		apiclient.Package{Version: "1.1.0-beta.1", DisplayName: "Foo Beta"},
+	)
+
+	interrupted, writes := e.run(t, scheduler.Batch{Full: true})
+	if interrupted {
+		t.Fatal("first run interrupted")
+	}
+	// the two latest pages, three subpages each for them and the version
+	// page, the existing repository subpage and the version summary; the
+	// version page is up to date
+	if want := 2 + 3*3 + 1 + 1; len(writes) != want {
+		t.Errorf("first run made %d writes, want %d", len(writes), want)
+	}
+	for _, w := range writes {
+		if w.Action != "edit" || !w.Bot {
+			t.Errorf("write %+v: want a bot edit", w)
+		}
+	}
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_version", "1.1.0-beta.1")
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_version/DisplayName", "Foo Beta")
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_stable_version", "1.0.0")
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_stable_version/License", "MIT")
+	e.wantPage(t, "Template:VPM/com.example.foo/1.0.0/Description", "A package.")
+	e.wantPage(t, "Template:VPM/com.example.foo/1.0.0/Repository", "Example")
+	if _, ok := e.wiki.Page(wikisync.VersionSummaryPageTitle); !ok {
+		t.Error("version summary missing")
+	}
+	for _, title := range []string{
+		"Template:VPM/com.example.foo/Latest_unstable_version",
+		"Template:VPM/com.example.foo/Latest_version/Repository",
+		"Template:VPM/com.example.foo/1.0.0/Repository_URL",
+	} {
+		if _, ok := e.wiki.Page(title); ok {
+			t.Errorf("%s created although it did not exist", title)
+		}
+	}
+
+	// nothing changed: the index is not modified and nothing is written
+	downloads := e.vpmm.IndexDownloads()
+	if _, writes := e.run(t, scheduler.Batch{}); len(writes) != 0 {
+		t.Errorf("unchanged run made %d writes: %v", len(writes), editedTitles(writes))
+	}
+	if got := e.vpmm.IndexDownloads(); got != downloads {
+		t.Errorf("unchanged index downloaded again")
+	}
+
+	// a new stable release only touches the latest pages
+	e.setVersions(t,
+		apiclient.Package{Version: "1.0.0", DisplayName: "Foo", Description: &desc, License: &license},
+		apiclient.Package{Version: "1.1.0-beta.1", DisplayName: "Foo Beta"},
+		apiclient.Package{Version: "1.1.0", DisplayName: "Foo", Description: &desc, License: &license},
+	)
+	_, writes = e.run(t, scheduler.Batch{})
+	edited := editedTitles(writes)
+	for _, title := range []string{
+		"Template:VPM/com.example.foo/Latest version",
+		"Template:VPM/com.example.foo/Latest version/DisplayName",
+		"Template:VPM/com.example.foo/Latest version/Description",
+		"Template:VPM/com.example.foo/Latest version/License",
+		"Template:VPM/com.example.foo/Latest stable version",
+		wikisync.VersionSummaryPageTitle,
+	} {
+		if !edited[title] {
+			t.Errorf("release did not edit %q", title)
+		}
+	}
+	if len(writes) != len(edited) {
+		t.Errorf("release edited a page twice: %d writes of %d pages", len(writes), len(edited))
+	}
+	if title := "Template:VPM/com.example.foo/1.0.0/Description"; edited[title] {
+		t.Errorf("release rewrote the unchanged %q", title)
+	}
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_version", "1.1.0")
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_stable_version", "1.1.0")
+	e.wantPage(t, "Template:VPM/com.example.foo/1.0.0/Description", "A package.")
+}
+
+func TestRunFullSyncRepositoryRenamed(t *testing.T) {
+	e := newSyncEnv(t)
+	e.wiki.SetPage("Template:VPM/com.example.foo/1.0.0", "1.0.0")
+	e.wiki.SetPage("Template:VPM/com.example.foo/1.0.0/Repository", "Example")
+	pkg := apiclient.Package{Version: "1.0.0", DisplayName: "Foo"}
+	e.setVersions(t, pkg)
+	if interrupted, _ := e.run(t, scheduler.Batch{Full: true}); interrupted {
+		t.Fatal("first run interrupted")
+	}
+
+	// only the listing's own name changed; the repository page still follows
+	e.setListing(t, "Renamed", pkg)
+	_, writes := e.run(t, scheduler.Batch{})
+	if edited := editedTitles(writes); !edited["Template:VPM/com.example.foo/1.0.0/Repository"] {
+		t.Errorf("rename did not edit the repository page: %v", edited)
+	}
+	e.wantPage(t, "Template:VPM/com.example.foo/1.0.0/Repository", "Renamed")
+}
+
+func TestRunFullSyncExcluded(t *testing.T) {
+	e := newSyncEnv(t)
+	const excluded = "com.example.secret"
+	e.wiki.SetPage(botconfig.DefaultTitle, `{"exclude": ["com.example.secret"]}`)
+	e.wiki.SetPage("Template:VPM/"+excluded+"/1.0.0", "not a version")
+	e.maintenance.Page = "Project:VPM bot/Maintenance"
+	listing := apiclient.RepositoryListing{Packages: map[string]apiclient.ListingPackage{}}
+	for _, name := range []string{excluded, testPackage} {
+		listing.Packages[name] = apiclient.ListingPackage{Versions: map[string]apiclient.Package{
+			"1.0.0": {Name: name, Version: "1.0.0", DisplayName: name},
+		}}
+	}
+	if err := e.vpmm.SetIndex(listing); err != nil {
+		t.Fatal(err)
+	}
+	if interrupted, _ := e.run(t, scheduler.Batch{Full: true}); interrupted {
+		t.Fatal("run interrupted")
+	}
+
+	for _, title := range []string{wikisync.VersionSummaryPageTitle, e.maintenance.Page} {
+		content, ok := e.wiki.Page(title)
+		switch {
+		case !ok:
+			t.Errorf("page %q missing", title)
+		case strings.Contains(content, excluded):
+			t.Errorf("page %q lists the excluded package:\n%s", title, content)
+		}
+	}
+	if content, _ := e.wiki.Page(wikisync.VersionSummaryPageTitle); !strings.Contains(content, testPackage) {
+		t.Errorf("version summary lacks %s:\n%s", testPackage, content)
+	}
+}
+
+func TestRunFullSyncWithoutListing(t *testing.T) {
+	e := newSyncEnv(t)
+	e.wiki.SetPage("Template:VPM/com.example.foo/Latest_version", "1.0.0")
+	e.vpmm.SetIndexStatus(http.StatusServiceUnavailable)
+	guard, err := safety.NewGuard(safety.Limits{}, "", true, e.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.guard = guard
+
+	interrupted, writes := e.run(t, scheduler.Batch{Full: true})
+	if !interrupted {
+		t.Error("run without a listing was not skipped")
+	}
+	if len(writes) != 0 {
+		t.Errorf("run without a listing made %d writes: %v", len(writes), editedTitles(writes))
+	}
+	if trip := e.guard.Tripped(); trip != nil {
+		t.Errorf("guard tripped: %v", trip)
+	}
+	if e.guard.Overridden() {
+		t.Error("override outlived the skipped run")
+	}
+	e.wantPage(t, "Template:VPM/com.example.foo/Latest_version", "1.0.0")
+}
+
+func TestRunFullSyncIndexDrop(t *testing.T) {
+	e := newSyncEnv(t)
+	e.wiki.SetPage("Template:VPM/com.example.foo/Latest_version", "1.0.0")
+	listing := apiclient.RepositoryListing{Packages: map[string]apiclient.ListingPackage{}}
+	for _, name := range []string{"com.example.a", "com.example.b", "com.example.c", "com.example.d", testPackage} {
+		listing.Packages[name] = apiclient.ListingPackage{Versions: map[string]apiclient.Package{
+			"1.0.0": {Name: name, Version: "1.0.0", DisplayName: name},
+		}}
+	}
+	if err := e.vpmm.SetIndex(listing); err != nil {
+		t.Fatal(err)
+	}
+	if interrupted, _ := e.run(t, scheduler.Batch{Full: true}); interrupted {
+		t.Fatal("first run interrupted")
+	}
+
+	// a truncated index must not delete the packages that went missing
+	e.setVersions(t, apiclient.Package{Version: "1.0.0", DisplayName: "Foo"})
+	interrupted, writes := e.run(t, scheduler.Batch{Full: true})
+	if !interrupted {
+		t.Error("run with a truncated index was not aborted")
+	}
+	if len(writes) != 0 {
+		t.Errorf("run with a truncated index made %d writes: %v", len(writes), editedTitles(writes))
+	}
+	if e.guard.Tripped() == nil {
+		t.Error("guard not tripped")
+	}
+}
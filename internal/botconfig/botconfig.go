@@ -0,0 +1,197 @@
+// Package botconfig reads the on-wiki configuration page through which wiki
+// admins control the connector without redeploying it: they can exclude
+// packages, pin the content of package fields, pause the bot and request a
+// full sync. The page holds a JSON object (JSON content model), e.g.
+//
+//	{
+//	  "paused": false,
+//	  "pauseReason": "",
+//	  "exclude": ["com.example.*"],
+//	  "pins": {"com.example.tool": {"DisplayName": "Example Tool"}},
+//	  "syncRequest": "2026-10-18 please resync"
+//	}
+//
+// A page that cannot be read or is invalid is reported and ignored: the
+// connector keeps the last valid configuration.
+package botconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// DefaultTitle is the default configuration page.
+const DefaultTitle = "Project:VPM bot/Config"
+
+// Config is the content of the configuration page.
+type Config struct {
+	// Paused stops all syncs, including running ones, until it is cleared.
+	Paused      bool   `json:"paused,omitempty"`
+	PauseReason string `json:"pauseReason,omitempty"`
+	// Exclude lists package name globs the connector must not touch, in
+	// addition to its deployment's filters.
+	Exclude []string `json:"exclude,omitempty"`
+	// Pins maps package names to field subpages (Description, DisplayName,
+	// License, Author_1 to Author_4, Repository, Repository_URL) and the
+	// wikitext they hold for every version of the package, whatever VPMM
+	// says.
+	Pins map[string]map[string]string `json:"pins,omitempty"`
+	// SyncRequest requests a full sync whenever its value changes.
+	SyncRequest string `json:"syncRequest,omitempty"`
+}
+
+// pinnableField matches the field subpages that can be pinned.
+var pinnableField = regexp.MustCompile(`^(Description|DisplayName|License|Author_[1-4]|Repository|Repository_URL)$`)
+
+// Parse decodes and validates the content of a configuration page. Unknown
+// keys are errors, so typos do not go unnoticed. An empty page is the zero
+// Config.
+func Parse(content string) (Config, error) {
+	var cfg Config
+	if strings.TrimSpace(content) == "" {
+		return cfg, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("decode: %w", err)
+	}
+	if dec.More() {
+		return Config{}, errors.New("decode: trailing data after the JSON object")
+	}
+	var errs []error
+	for i, pattern := range cfg.Exclude {
+		if strings.TrimSpace(pattern) == "" {
+			errs = append(errs, fmt.Errorf("exclude[%d]: empty pattern", i))
+		}
+	}
+	for name, fields := range cfg.Pins {
+		if strings.TrimSpace(name) == "" || strings.Contains(name, "/") {
+			errs = append(errs, fmt.Errorf("pins: invalid package name %q", name))
+		}
+		for field := range fields {
+			if !pinnableField.MatchString(strings.ReplaceAll(field, " ", "_")) {
+				errs = append(errs, fmt.Errorf("pins[%s]: field %q cannot be pinned", name, field))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Update is the result of loading the configuration page.
+type Update struct {
+	Config
+	// SyncRequested reports that SyncRequest changed since the previous load.
+	SyncRequested bool
+	// Resumed reports that the bot was paused at the previous load and is
+	// not anymore.
+	Resumed bool
+}
+
+// Source loads the configuration page from a PageStore and remembers the last
+// valid configuration. It is safe for concurrent use.
+type Source struct {
+	store  pagestore.PageStore
+	title  string
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	current  Config
+	loaded   bool
+	revision int64
+	// invalid is the revision last reported as invalid, so it is reported once
+	invalid int64
+}
+
+// NewSource returns a Source for the page title (DefaultTitle if empty) of
+// store.
+func NewSource(store pagestore.PageStore, title string, logger *slog.Logger) *Source {
+	if title == "" {
+		title = DefaultTitle
+	}
+	return &Source{store: store, title: title, logger: logging.OrDiscard(logger)}
+}
+
+// Title returns the configuration page.
+func (s *Source) Title() string {
+	return s.title
+}
+
+// Current returns the last valid configuration.
+func (s *Source) Current() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Paused reports whether the last valid configuration pauses the bot.
+func (s *Source) Paused() bool {
+	return s.Current().Paused
+}
+
+// Load reads the configuration page. A missing page is the zero Config; a
+// page that cannot be read or is invalid is logged and the last valid
+// configuration is kept. The first load never reports a sync request, since
+// the connector syncs after starting anyway.
+func (s *Source) Load(ctx context.Context) Update {
+	ctx = logging.With(ctx, logging.KeyPage, s.title)
+	var cfg Config
+	var revision int64
+	page, err := s.store.Get(ctx, s.title)
+	switch {
+	case pagestore.IsNotFound(err):
+	case err != nil:
+		s.logger.WarnContext(ctx, "read bot config page, keeping the previous configuration", "error", err)
+		return Update{Config: s.Current()}
+	default:
+		revision = page.Revision.ID
+		if cfg, err = Parse(page.Content); err != nil {
+			s.mu.Lock()
+			report := s.invalid != revision || revision == 0
+			s.invalid = revision
+			s.mu.Unlock()
+			if report {
+				s.logger.ErrorContext(ctx, "invalid bot config page ignored, keeping the previous configuration",
+					"revision", revision, "user", page.Revision.User, "error", err)
+			}
+			return Update{Config: s.Current()}
+		}
+	}
+
+	s.mu.Lock()
+	prev, loaded, prevRevision := s.current, s.loaded, s.revision
+	s.current, s.loaded, s.revision = cfg, true, revision
+	s.mu.Unlock()
+
+	u := Update{
+		Config:        cfg,
+		SyncRequested: loaded && cfg.SyncRequest != "" && cfg.SyncRequest != prev.SyncRequest,
+		Resumed:       prev.Paused && !cfg.Paused,
+	}
+	if !loaded || revision != prevRevision {
+		s.logger.InfoContext(ctx, "bot config loaded", "revision", revision, "paused", cfg.Paused,
+			"excluded", len(cfg.Exclude), "pinned_packages", len(cfg.Pins))
+	}
+	switch {
+	case cfg.Paused && !prev.Paused:
+		s.logger.WarnContext(ctx, "bot paused by the wiki config page", "reason", cfg.PauseReason)
+	case u.Resumed:
+		s.logger.InfoContext(ctx, "bot resumed by the wiki config page")
+	}
+	if u.SyncRequested {
+		s.logger.InfoContext(ctx, "sync requested by the wiki config page", "request", cfg.SyncRequest)
+	}
+	return u
+}
@@ -0,0 +1,223 @@
+// Package breaker implements a circuit breaker for a remote service that can
+// go away for a while (outages, maintenance windows). After Threshold
+// consecutive outage errors the breaker opens and callers stop sending
+// requests; once the cooldown is over, a single probe decides whether it
+// closes again or stays open for a longer cooldown.
+package breaker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Default settings.
+const (
+	DefaultThreshold   = 3
+	DefaultCooldown    = 30 * time.Second
+	DefaultMaxCooldown = 10 * time.Minute
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets requests through.
+	Closed State = iota
+	// Open rejects requests until the cooldown is over and a probe succeeds.
+	Open
+	// HalfOpen means a probe is running; requests are still rejected.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config configures a Breaker. Zero values select the defaults.
+type Config struct {
+	// Threshold is the number of consecutive outage errors that opens the
+	// breaker.
+	Threshold int
+	// Cooldown is how long the breaker stays open before the first probe;
+	// it doubles after every failed probe up to MaxCooldown.
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	// OnStateChange is called after every transition, outside the lock.
+	OnStateChange func(from, to State, cause error)
+}
+
+// Breaker is safe for concurrent use.
+type Breaker struct {
+	cfg Config
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+	lastErr   error
+}
+
+// New returns a closed Breaker.
+func New(cfg Config) *Breaker {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = DefaultCooldown
+	}
+	if cfg.MaxCooldown <= 0 {
+		cfg.MaxCooldown = DefaultMaxCooldown
+	}
+	cfg.MaxCooldown = max(cfg.MaxCooldown, cfg.Cooldown)
+	return &Breaker{cfg: cfg, cooldown: cfg.Cooldown}
+}
+
+// Allow reports whether requests may be sent.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == Closed
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Success records a request that reached the service. It resets the count
+// of consecutive failures.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Closed {
+		b.failures = 0
+	}
+}
+
+// Failure records an outage error; the Threshold-th in a row opens the
+// breaker.
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	if b.state != Closed {
+		b.mu.Unlock()
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.failures < b.cfg.Threshold {
+		b.mu.Unlock()
+		return
+	}
+	b.openLocked(time.Now())
+	b.mu.Unlock()
+	b.notify(Closed, Open, err)
+}
+
+// Trip records an error that leaves no doubt the service is unavailable and
+// opens a closed breaker at once.
+func (b *Breaker) Trip(err error) {
+	b.mu.Lock()
+	if b.state != Closed {
+		b.mu.Unlock()
+		return
+	}
+	b.lastErr = err
+	b.openLocked(time.Now())
+	b.mu.Unlock()
+	b.notify(Closed, Open, err)
+}
+
+// ProbeDue reports whether the breaker is open and its cooldown is over.
+func (b *Breaker) ProbeDue() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == Open && !time.Now().Before(b.openUntil)
+}
+
+// RetryAt returns when the next probe is due; zero unless open.
+func (b *Breaker) RetryAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return time.Time{}
+	}
+	return b.openUntil
+}
+
+// Probe runs check if a probe is due and closes the breaker when it
+// succeeds; otherwise the breaker stays open for a doubled cooldown. It
+// reports whether the breaker is closed afterwards.
+func (b *Breaker) Probe(ctx context.Context, check func(context.Context) error) bool {
+	b.mu.Lock()
+	if b.state != Open || time.Now().Before(b.openUntil) {
+		closed := b.state == Closed
+		b.mu.Unlock()
+		return closed
+	}
+	b.state = HalfOpen
+	b.mu.Unlock()
+
+	err := check(ctx)
+
+	b.mu.Lock()
+	if err != nil {
+		if ctx.Err() != nil {
+			// not the service's fault; probe again right away next time
+			b.state = Open
+			b.mu.Unlock()
+			return false
+		}
+		b.lastErr = err
+		b.cooldown = min(b.cooldown*2, b.cfg.MaxCooldown)
+		b.openLocked(time.Now())
+		b.mu.Unlock()
+		b.notify(HalfOpen, Open, err)
+		return false
+	}
+	b.state = Closed
+	b.failures = 0
+	b.cooldown = b.cfg.Cooldown
+	b.lastErr = nil
+	b.mu.Unlock()
+	b.notify(HalfOpen, Closed, nil)
+	return true
+}
+
+func (b *Breaker) openLocked(now time.Time) {
+	b.state = Open
+	b.failures = 0
+	b.openUntil = now.Add(b.cooldown)
+}
+
+func (b *Breaker) notify(from, to State, cause error) {
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to, cause)
+	}
+}
+
+// LogValue implements slog.LogValuer.
+func (b *Breaker) LogValue() slog.Value {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	attrs := []slog.Attr{slog.String("state", b.state.String())}
+	if b.state != Closed {
+		attrs = append(attrs, slog.Time("retry_at", b.openUntil), slog.Duration("cooldown", b.cooldown))
+	}
+	if b.lastErr != nil {
+		attrs = append(attrs, slog.String("last_error", b.lastErr.Error()))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,76 @@
+// Package checkpoint persists the packages a sync could not finish before
+// the connector shut down, so the next start can process them first.
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/atomicfile"
+)
+
+// version is bumped when the file format changes incompatibly.
+const version = 1
+
+// Checkpoint records unfinished sync work.
+type Checkpoint struct {
+	Version   int       `json:"version"`
+	WrittenAt time.Time `json:"writtenAt"`
+	// RunID is the sync run that was interrupted, if any.
+	RunID string `json:"runId,omitempty"`
+	// Full is set when a full reconciliation was requested but not finished.
+	Full bool `json:"full,omitempty"`
+	// Packages are the unprocessed packages in the order they were due.
+	Packages []string `json:"packages"`
+}
+
+// Empty reports whether there is nothing to resume.
+func (c *Checkpoint) Empty() bool {
+	return c == nil || (!c.Full && len(c.Packages) == 0)
+}
+
+// Load reads the checkpoint at path. A missing file yields nil and no error.
+func Load(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint %s: %w", path, err)
+	}
+	if cp.Version != version {
+		return nil, fmt.Errorf("checkpoint %s: unsupported version %d", path, cp.Version)
+	}
+	return &cp, nil
+}
+
+// Save writes cp to path atomically, so a crash never leaves a torn file.
+func Save(path string, cp *Checkpoint) error {
+	cp.Version = version
+	if cp.WrittenAt.IsZero() {
+		cp.WrittenAt = time.Now().UTC()
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+	if err := atomicfile.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the checkpoint at path; a missing file is not an error.
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove checkpoint: %w", err)
+	}
+	return nil
+}
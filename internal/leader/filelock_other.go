@@ -0,0 +1,27 @@
+//go:build !unix
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// FileLock is only supported on unix systems.
+type FileLock struct{}
+
+// NewFileLock returns a FileLock whose TryAcquire always fails.
+func NewFileLock(string) *FileLock {
+	return &FileLock{}
+}
+
+// TryAcquire implements Lock.
+func (*FileLock) TryAcquire(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("file lock: not supported on this platform")
+}
+
+// Release implements Lock.
+func (*FileLock) Release(context.Context, string) error {
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileLock is a Lock for replicas on one host, backed by flock(2) on a file.
+// The kernel drops the lock when the holding process dies, so the TTL is not
+// needed.
+type FileLock struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileLock returns a FileLock on path. The file is created if needed.
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+// TryAcquire implements Lock.
+func (l *FileLock) TryAcquire(_ context.Context, identity string, _ time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("open lock file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("lock %s: %w", l.path, err)
+	}
+	// the holder's name helps when debugging a stuck standby
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(identity+"\n"), 0)
+	}
+	l.f = f
+	return true, nil
+}
+
+// Release implements Lock.
+func (l *FileLock) Release(context.Context, string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	_ = syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	err := l.f.Close()
+	l.f = nil
+	if err != nil {
+		return fmt.Errorf("close lock file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// In-cluster service account files.
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenFile         = serviceAccountDir + "/token"
+	caFile            = serviceAccountDir + "/ca.crt"
+	namespaceFile     = serviceAccountDir + "/namespace"
+)
+
+// microTime is the format of Lease timestamps (metav1.MicroTime).
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// LeaseLock is a Lock backed by a coordination.k8s.io/v1 Lease, talking to
+// the API server with the pod's service account. Updates carry the
+// resourceVersion that was read, so concurrent writers get 409 Conflict.
+// The service account needs get, create and update on leases.
+type LeaseLock struct {
+	namespace string
+	name      string
+	baseURL   string
+	client    *http.Client
+}
+
+// lease is the subset of the Lease object the lock reads and writes.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+// errLeaseConflict means another writer updated or created the Lease first.
+var errLeaseConflict = errors.New("lease conflict")
+
+// NewLeaseLock returns a LeaseLock on the Lease name using the in-cluster
+// configuration. An empty namespace means the pod's own namespace.
+func NewLeaseLock(namespace, name string) (*LeaseLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("lease lock: not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST unset)")
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("lease lock: read namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("lease lock: read cluster ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("lease lock: no certificates in cluster ca")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &LeaseLock{
+		namespace: namespace,
+		name:      name,
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// TryAcquire implements Lock.
+func (l *LeaseLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	cur, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if cur == nil {
+		cur = &lease{Metadata: leaseMetadata{Name: l.name, Namespace: l.namespace}}
+	}
+	holder := deref(cur.Spec.HolderIdentity)
+	if holder != "" && holder != identity && !leaseExpired(cur.Spec, now) {
+		return false, nil
+	}
+	nowStr := now.Format(microTime)
+	seconds := int32((ttl + time.Second - 1) / time.Second)
+	if holder != identity {
+		transitions := deref(cur.Spec.LeaseTransitions)
+		if holder != "" {
+			transitions++
+		}
+		cur.Spec.LeaseTransitions = &transitions
+		cur.Spec.AcquireTime = &nowStr
+	}
+	cur.Spec.HolderIdentity = &identity
+	cur.Spec.LeaseDurationSeconds = &seconds
+	cur.Spec.RenewTime = &nowStr
+	err = l.put(ctx, cur)
+	if errors.Is(err, errLeaseConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release implements Lock. The Lease is kept but marked free.
+func (l *LeaseLock) Release(ctx context.Context, identity string) error {
+	cur, err := l.get(ctx)
+	if err != nil || cur == nil || deref(cur.Spec.HolderIdentity) != identity {
+		return err
+	}
+	empty, one := "", int32(1)
+	cur.Spec.HolderIdentity = &empty
+	cur.Spec.LeaseDurationSeconds = &one
+	if err := l.put(ctx, cur); err != nil && !errors.Is(err, errLeaseConflict) {
+		return err
+	}
+	return nil
+}
+
+func leaseExpired(spec leaseSpec, now time.Time) bool {
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(microTime, *spec.RenewTime)
+	if err != nil {
+		renewed, err = time.Parse(time.RFC3339, *spec.RenewTime)
+	}
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func (l *LeaseLock) collectionURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.baseURL, l.namespace)
+}
+
+// get returns the Lease, or nil if it does not exist.
+func (l *LeaseLock) get(ctx context.Context) (*lease, error) {
+	resp, body, err := l.do(ctx, http.MethodGet, l.collectionURL()+"/"+l.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var out lease
+		if err := json.Unmarshal(body, &out); err != nil {
+			return nil, fmt.Errorf("lease lock: decode lease: %w", err)
+		}
+		return &out, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError("get", resp, body)
+	}
+}
+
+// put creates the Lease when it has no resourceVersion yet and replaces it
+// otherwise.
+func (l *LeaseLock) put(ctx context.Context, obj *lease) error {
+	obj.APIVersion, obj.Kind = "coordination.k8s.io/v1", "Lease"
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("lease lock: encode lease: %w", err)
+	}
+	method, target, verb := http.MethodPut, l.collectionURL()+"/"+l.name, "update"
+	if obj.Metadata.ResourceVersion == "" {
+		method, target, verb = http.MethodPost, l.collectionURL(), "create"
+	}
+	resp, body, err := l.do(ctx, method, target, data)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return errLeaseConflict
+	default:
+		return statusError(verb, resp, body)
+	}
+}
+
+func (l *LeaseLock) do(ctx context.Context, method, target string, body []byte) (*http.Response, []byte, error) {
+	// the token is projected and rotated, so read it for every request
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lease lock: read service account token: %w", err)
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lease lock: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lease lock: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("lease lock: read response: %w", err)
+	}
+	return resp, data, nil
+}
+
+// statusError describes a failed API call, using the Status message if any.
+func statusError(verb string, resp *http.Response, body []byte) error {
+	var status struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &status) == nil && status.Message != "" {
+		return fmt.Errorf("lease lock: %s lease: %s: %s", verb, resp.Status, status.Message)
+	}
+	return fmt.Errorf("lease lock: %s lease: %s", verb, resp.Status)
+}
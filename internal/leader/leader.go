@@ -0,0 +1,179 @@
+// Package leader makes sure only one connector replica writes to the wiki.
+// Replicas compete for a Lock (a lock file, a wiki page or a Kubernetes
+// Lease); the Elector keeps renewing it and reports whether this replica is
+// the leader. Standbys keep their SSE streams connected so they can take over
+// as soon as the leader's lock expires.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+)
+
+// Default timings of an Elector.
+const (
+	DefaultTTL           = 30 * time.Second
+	DefaultRenewInterval = 10 * time.Second
+)
+
+// Lock is a lock shared by all replicas. Implementations must be safe for
+// use by one Elector at a time.
+type Lock interface {
+	// TryAcquire takes the lock for identity, or renews it if identity
+	// already holds it, for ttl. It reports whether identity holds the lock
+	// afterwards; losing a race is not an error.
+	TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error)
+	// Release gives the lock up if identity holds it.
+	Release(ctx context.Context, identity string) error
+}
+
+// Config configures an Elector.
+type Config struct {
+	// Identity names this replica in the lock; defaults to DefaultIdentity.
+	Identity string
+	// TTL is how long an unrenewed lock stays valid.
+	TTL time.Duration
+	// RenewInterval is how often the lock is acquired or renewed. It must be
+	// well below TTL; a leader that could not renew for TTL-RenewInterval
+	// steps down before others may take over.
+	RenewInterval time.Duration
+	// OnStartedLeading and OnStoppedLeading are called from Run when
+	// leadership changes.
+	OnStartedLeading func()
+	OnStoppedLeading func()
+	Logger           *slog.Logger
+}
+
+// Elector runs the election for one replica.
+type Elector struct {
+	lock   Lock
+	cfg    Config
+	logger *slog.Logger
+	leader atomic.Bool
+
+	mu        sync.Mutex
+	lastRenew time.Time
+}
+
+// NewElector returns an Elector competing for lock.
+func NewElector(lock Lock, cfg Config) (*Elector, error) {
+	if cfg.Identity == "" {
+		cfg.Identity = DefaultIdentity()
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = min(DefaultRenewInterval, cfg.TTL/3)
+	}
+	if cfg.RenewInterval >= cfg.TTL {
+		return nil, fmt.Errorf("leader election: renew interval %s must be below ttl %s", cfg.RenewInterval, cfg.TTL)
+	}
+	return &Elector{
+		lock:   lock,
+		cfg:    cfg,
+		logger: logging.OrDiscard(cfg.Logger).With("identity", cfg.Identity),
+	}, nil
+}
+
+// DefaultIdentity returns the host name (the pod name in Kubernetes) and the
+// process ID.
+func DefaultIdentity() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Identity returns the name this replica competes under.
+func (e *Elector) Identity() string {
+	return e.cfg.Identity
+}
+
+// IsLeader reports whether this replica currently holds the lock.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run competes for the lock until ctx is done, then releases it.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+	for {
+		e.tryOnce(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) tryOnce(ctx context.Context) {
+	attemptCtx, cancel := context.WithTimeout(ctx, e.cfg.RenewInterval)
+	defer cancel()
+	ok, err := e.lock.TryAcquire(attemptCtx, e.cfg.Identity, e.cfg.TTL)
+	if ctx.Err() != nil {
+		return
+	}
+	now := time.Now()
+	switch {
+	case err != nil:
+		e.logger.Warn("leader election: acquire lock", "error", err)
+		e.mu.Lock()
+		expired := now.Sub(e.lastRenew) >= e.cfg.TTL-e.cfg.RenewInterval
+		e.mu.Unlock()
+		if e.IsLeader() && expired {
+			// others may take over soon; stop writing before they do
+			e.setLeader(false, "lock could not be renewed")
+		}
+	case ok:
+		e.mu.Lock()
+		e.lastRenew = now
+		e.mu.Unlock()
+		if !e.IsLeader() {
+			e.setLeader(true, "lock acquired")
+		}
+	default:
+		if e.IsLeader() {
+			e.setLeader(false, "lock taken over")
+		}
+	}
+}
+
+func (e *Elector) setLeader(leader bool, reason string) {
+	e.leader.Store(leader)
+	if leader {
+		e.logger.Info("leader election: became leader", "reason", reason)
+		if e.cfg.OnStartedLeading != nil {
+			e.cfg.OnStartedLeading()
+		}
+		return
+	}
+	e.logger.Warn("leader election: stepped down", "reason", reason)
+	if e.cfg.OnStoppedLeading != nil {
+		e.cfg.OnStoppedLeading()
+	}
+}
+
+func (e *Elector) release() {
+	if !e.leader.Swap(false) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewInterval)
+	defer cancel()
+	if err := e.lock.Release(ctx, e.cfg.Identity); err != nil {
+		e.logger.Warn("leader election: release lock", "error", err)
+		return
+	}
+	e.logger.Info("leader election: released lock")
+}
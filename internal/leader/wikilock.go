@@ -0,0 +1,145 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// DefaultPageLockSettle is how long a PageLock waits after claiming a lease
+// before it reads the page back to confirm the claim.
+const DefaultPageLockSettle = 5 * time.Second
+
+// PageLock is a Lock stored as a JSON lease on a wiki page. Acquiring and
+// renewing are conditional edits on the revision that was read. The store
+// sends that revision as baserevid without a basetimestamp, and MediaWiki
+// then does not report a conflict when the intervening edit was made by the
+// same account, which is the case for replicas sharing a bot account. Every
+// write therefore carries a random nonce, and the lock only counts as held
+// once the page is read back with this replica's holder and nonce at a
+// newer revision. A new claim is read back after a settle delay, giving a
+// competing claim time to land; the last one written wins. Every renewal is
+// a page revision: use a generous TTL.
+type PageLock struct {
+	store  pagestore.PageStore
+	title  string
+	settle time.Duration
+}
+
+// pageLease is the content of the lock page.
+type pageLease struct {
+	Holder      string    `json:"holder"`
+	AcquiredAt  time.Time `json:"acquiredAt"`
+	RenewedAt   time.Time `json:"renewedAt"`
+	TTLSeconds  int64     `json:"ttlSeconds"`
+	Transitions int       `json:"transitions"`
+	// Nonce identifies the write that produced the lease.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+func (l pageLease) expired(now time.Time) bool {
+	return l.Holder == "" || now.After(l.RenewedAt.Add(time.Duration(l.TTLSeconds)*time.Second))
+}
+
+// NewPageLock returns a PageLock on the page title of store.
+func NewPageLock(store pagestore.PageStore, title string) *PageLock {
+	return &PageLock{store: store, title: title, settle: DefaultPageLockSettle}
+}
+
+// TryAcquire implements Lock.
+func (l *PageLock) TryAcquire(ctx context.Context, identity string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	cur, rev, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if cur.Holder != identity && !cur.expired(now) {
+		return false, nil
+	}
+	next := cur
+	summary := "renew connector leader lock"
+	if cur.Holder != identity {
+		next.AcquiredAt = now
+		next.Transitions++
+		summary = "acquire connector leader lock"
+	}
+	next.Holder = identity
+	next.RenewedAt = now
+	next.TTLSeconds = int64((ttl + time.Second - 1) / time.Second)
+	next.Nonce = rand.Text()
+	if err := l.write(ctx, next, rev, summary); err != nil {
+		if pagestore.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if cur.Holder != identity {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(l.settle):
+		}
+	}
+	return l.confirm(ctx, identity, next.Nonce, rev)
+}
+
+// confirm reads the lock page back and reports whether it still holds the
+// lease written with nonce, at a revision newer than base.
+func (l *PageLock) confirm(ctx context.Context, identity, nonce string, base int64) (bool, error) {
+	cur, rev, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return cur.Holder == identity && cur.Nonce == nonce && rev > base, nil
+}
+
+// Release implements Lock.
+func (l *PageLock) Release(ctx context.Context, identity string) error {
+	cur, rev, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if cur.Holder != identity {
+		return nil
+	}
+	cur.Holder = ""
+	cur.RenewedAt = time.Now().UTC()
+	if err := l.write(ctx, cur, rev, "release connector leader lock"); err != nil && !pagestore.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// read returns the lease and the revision it was read from; a missing page
+// is an expired lease at revision 0.
+func (l *PageLock) read(ctx context.Context) (pageLease, int64, error) {
+	page, err := l.store.Get(ctx, l.title)
+	if pagestore.IsNotFound(err) {
+		return pageLease{}, 0, nil
+	}
+	if err != nil {
+		return pageLease{}, 0, fmt.Errorf("read lock page: %w", err)
+	}
+	var lease pageLease
+	if err := json.Unmarshal([]byte(page.Content), &lease); err != nil {
+		// someone replaced the page with something else; treat as free
+		return pageLease{}, page.Revision.ID, nil
+	}
+	return lease, page.Revision.ID, nil
+}
+
+func (l *PageLock) write(ctx context.Context, lease pageLease, rev int64, summary string) error {
+	data, err := json.MarshalIndent(lease, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode lock page: %w", err)
+	}
+	opts := pagestore.PutOptions{Summary: summary, Bot: true, BaseRevision: rev, CreateOnly: rev == 0}
+	if err := l.store.Put(ctx, l.title, string(data), opts); err != nil {
+		return fmt.Errorf("write lock page: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package logging builds the connector's structured logger and carries
+// correlation IDs (sync run, package, page) through contexts so every log
+// line of a sync can be attributed.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Correlation attribute keys.
+const (
+	KeySyncRun = "sync_run"
+	KeyPackage = "package"
+	KeyPage    = "page"
+)
+
+// ParseLevel parses "debug", "info", "warn" or "error"; empty means info.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if strings.TrimSpace(s) == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// NewHandler returns a JSON (format "json" or empty) or text (format "text")
+// handler writing to w at the given level. The handler adds the correlation
+// attributes stored in the context of each log call.
+func NewHandler(w io.Writer, level slog.Level, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want json or text)", format)
+	}
+	return &contextHandler{next: h}, nil
+}
+
+// OrDiscard returns logger, or a logger that drops everything when it is nil.
+func OrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return logger
+}
+
+// NewRunID returns a short random identifier for a sync run.
+func NewRunID() string {
+	var b [6]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type ctxKey struct{}
+
+// With returns a context whose log calls carry the given key/value pairs in
+// addition to those already attached. Later values replace earlier ones with
+// the same key.
+func With(ctx context.Context, args ...any) context.Context {
+	add := slog.Group("", args...).Value.Group()
+	prev := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(prev)+len(add))
+	for _, a := range prev {
+		if !hasKey(add, a.Key) {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, add...)
+	return context.WithValue(ctx, ctxKey{}, merged)
+}
+
+// Value returns the value attached to ctx with With under key, or "".
+func Value(ctx context.Context, key string) string {
+	for _, a := range attrsFrom(ctx) {
+		if a.Key == key {
+			return a.Value.String()
+		}
+	}
+	return ""
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler adds the context's correlation attributes to each record,
+// skipping keys the record already sets itself.
+type contextHandler struct {
+	next slog.Handler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	attrs := attrsFrom(ctx)
+	if len(attrs) > 0 {
+		own := make(map[string]bool, rec.NumAttrs())
+		rec.Attrs(func(a slog.Attr) bool {
+			own[a.Key] = true
+			return true
+		})
+		rec = rec.Clone()
+		for _, a := range attrs {
+			if !own[a.Key] {
+				rec.AddAttrs(a)
+			}
+		}
+	}
+	return h.next.Handle(ctx, rec)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name)}
+}
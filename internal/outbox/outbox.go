@@ -0,0 +1,196 @@
+// Package outbox keeps page writes that could not reach the wiki because it
+// was down or read-only, and delivers them once it is back. Store wraps a
+// pagestore.PageStore with a circuit breaker and queues writes in an Outbox
+// while the breaker is open; the Outbox persists them to a file so they
+// survive restarts.
+package outbox
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/atomicfile"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// version identifies the layout of the outbox file; Open rejects files of
+// any other version rather than replaying writes it may misread.
+const version = 1
+
+// Write is a queued page edit or deletion.
+type Write struct {
+	// Seq orders the writes; it increases with every queued write.
+	Seq    int64  `json:"seq"`
+	Title  string `json:"title"`
+	Delete bool   `json:"delete,omitempty"`
+	// Content is the page text of an edit.
+	Content string `json:"content,omitempty"`
+	// Summary is the edit summary, or the reason of a deletion.
+	Summary  string    `json:"summary,omitempty"`
+	Bot      bool      `json:"bot,omitempty"`
+	QueuedAt time.Time `json:"queuedAt"`
+	// BaseRevision is the revision of the page the write was based on, and
+	// Missing records that the page did not exist then. A write whose page
+	// has changed since is dropped on delivery. Writes with neither are
+	// delivered unconditionally.
+	BaseRevision int64 `json:"baseRevision,omitempty"`
+	Missing      bool  `json:"missing,omitempty"`
+}
+
+type file struct {
+	Version int     `json:"version"`
+	Writes  []Write `json:"writes"`
+}
+
+// Outbox is an ordered queue holding at most one write per page: a newer
+// write for a page supersedes the queued one. It is safe for concurrent use.
+type Outbox struct {
+	path string
+
+	mu     sync.Mutex
+	writes []Write
+	seq    int64
+}
+
+// Open loads the outbox persisted at path, or starts an empty one if the
+// file does not exist. An empty path keeps the outbox in memory only.
+func Open(path string) (*Outbox, error) {
+	o := &Outbox{path: path}
+	if path == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode outbox %s: %w", path, err)
+	}
+	if f.Version != version {
+		return nil, fmt.Errorf("outbox %s: unsupported version %d", path, f.Version)
+	}
+	o.writes = f.Writes
+	slices.SortFunc(o.writes, func(a, b Write) int { return cmp.Compare(a.Seq, b.Seq) })
+	if n := len(o.writes); n > 0 {
+		o.seq = o.writes[n-1].Seq
+	}
+	return o, nil
+}
+
+// Add queues w after all other writes, dropping a queued write for the same
+// page. It reports whether one was dropped.
+func (o *Outbox) Add(w Write) (superseded bool, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	next := slices.DeleteFunc(slices.Clone(o.writes), func(q Write) bool { return samePage(q.Title, w.Title) })
+	superseded = len(next) < len(o.writes)
+	w.Seq = o.seq + 1
+	if w.QueuedAt.IsZero() {
+		w.QueuedAt = time.Now().UTC()
+	}
+	next = append(next, w)
+	if err := o.saveLocked(next); err != nil {
+		return false, err
+	}
+	o.writes = next
+	o.seq = w.Seq
+	return superseded, nil
+}
+
+// Peek returns the oldest queued write.
+func (o *Outbox) Peek() (Write, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.writes) == 0 {
+		return Write{}, false
+	}
+	return o.writes[0], true
+}
+
+// Lookup returns the queued write for title, if any.
+func (o *Outbox) Lookup(title string) (Write, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, w := range o.writes {
+		if samePage(w.Title, title) {
+			return w, true
+		}
+	}
+	return Write{}, false
+}
+
+// Pending returns a copy of the queued writes, oldest first.
+func (o *Outbox) Pending() []Write {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.writes)
+}
+
+// Len returns the number of queued writes.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.writes)
+}
+
+// Remove drops the write with the given sequence number, e.g. after it was
+// delivered. A write that is no longer queued is ignored.
+func (o *Outbox) Remove(seq int64) error {
+	return o.drop(func(w Write) bool { return w.Seq == seq })
+}
+
+// Forget drops the queued write for title, e.g. because a newer write
+// reached the wiki directly.
+func (o *Outbox) Forget(title string) error {
+	return o.drop(func(w Write) bool { return samePage(w.Title, title) })
+}
+
+func (o *Outbox) drop(match func(Write) bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	next := slices.DeleteFunc(slices.Clone(o.writes), match)
+	if len(next) == len(o.writes) {
+		return nil
+	}
+	if err := o.saveLocked(next); err != nil {
+		return err
+	}
+	o.writes = next
+	return nil
+}
+
+// saveLocked persists writes atomically, so a crash never leaves a torn
+// file. An empty outbox removes the file.
+func (o *Outbox) saveLocked(writes []Write) error {
+	if o.path == "" {
+		return nil
+	}
+	if len(writes) == 0 {
+		if err := os.Remove(o.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove outbox: %w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(file{Version: version, Writes: writes})
+	if err != nil {
+		return fmt.Errorf("encode outbox: %w", err)
+	}
+	if err := atomicfile.WriteFile(o.path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	return nil
+}
+
+func samePage(a, b string) bool {
+	return pagestore.NormalizeTitle(a) == pagestore.NormalizeTitle(b)
+}
@@ -0,0 +1,433 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/breaker"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/retry"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// ErrUnavailable is returned (wrapped) by Store while its breaker is open,
+// for requests that cannot be queued: reads, conditional writes and writes
+// of pages whose current revision is unknown.
+var ErrUnavailable = errors.New("page store unavailable")
+
+// tick is how often Run checks for due probes and undelivered writes.
+const tick = time.Second
+
+// Pinger is implemented by stores that can report whether they accept writes
+// (mediawiki.MediaWikiClient fails its Ping while the wiki is read-only).
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Config configures a Store.
+type Config struct {
+	Breaker breaker.Config
+	// Outage classifies write errors that count towards opening the
+	// breaker; defaults to transient errors as marked by package retry
+	// (network errors, 5xx, maxlag). pagestore.ErrReadOnly always opens the
+	// breaker at once.
+	Outage func(error) bool
+	// OnRecovered is called when the breaker has closed again and the
+	// outbox has been drained.
+	OnRecovered func()
+	// Active reports whether Run may probe and deliver, e.g. only while this
+	// replica is the leader; nil means always.
+	Active func() bool
+	Logger *slog.Logger
+}
+
+// Store is a pagestore.PageStore that guards another one with a circuit
+// breaker driven by the outcome of writes: a wiki in read-only mode still
+// answers reads. While the breaker is open, unconditional writes are queued in the
+// outbox and reported as done. Everything else fails with ErrUnavailable:
+// reads, except Get of a page with a queued write, and conditional writes.
+// Callers are expected to stop once Paused reports true and to sync again
+// after OnRecovered, so the outbox mostly holds the writes that were under
+// way when the wiki went away. Reads see queued writes, so callers comparing
+// content do not queue the same write twice. Run probes the store and
+// delivers the outbox in order once it is back. A queued write is based on
+// the revision of the page the Store last read; if someone edited the page
+// in the meantime, the write is dropped instead of overwriting their edit.
+type Store struct {
+	inner   pagestore.PageStore
+	box     *Outbox
+	breaker *breaker.Breaker
+	cfg     Config
+	logger  *slog.Logger
+
+	// writeMu orders direct writes and outbox deliveries, so a delivery can
+	// never overwrite a newer direct write of the same page
+	writeMu sync.Mutex
+
+	// revisions holds the revision of each page last read from the store,
+	// keyed by normalized title; 0 means the page did not exist
+	revMu     sync.Mutex
+	revisions map[string]int64
+}
+
+var _ pagestore.PageStore = (*Store)(nil)
+
+// NewStore wraps inner, queueing into box.
+func NewStore(inner pagestore.PageStore, box *Outbox, cfg Config) *Store {
+	s := &Store{inner: inner, box: box, cfg: cfg, logger: logging.OrDiscard(cfg.Logger), revisions: map[string]int64{}}
+	if s.cfg.Outage == nil {
+		s.cfg.Outage = func(err error) bool {
+			var transient *retry.Error
+			return errors.As(err, &transient)
+		}
+	}
+	onChange := cfg.Breaker.OnStateChange
+	cfg.Breaker.OnStateChange = func(from, to breaker.State, cause error) {
+		switch to {
+		case breaker.Open:
+			if from == breaker.Closed {
+				s.logger.Warn("wiki unavailable, pausing sync and queueing writes", "breaker", s.breaker, "queued", s.box.Len(), "error", cause)
+			} else {
+				s.logger.Info("wiki still unavailable", "breaker", s.breaker, "queued", s.box.Len())
+			}
+		case breaker.Closed:
+			s.logger.Info("wiki available again", "queued", s.box.Len())
+		}
+		if onChange != nil {
+			onChange(from, to, cause)
+		}
+	}
+	s.breaker = breaker.New(cfg.Breaker)
+	return s
+}
+
+// Paused reports whether the breaker is open, i.e. writes are being queued.
+func (s *Store) Paused() bool {
+	return !s.breaker.Allow()
+}
+
+// Queued returns the number of writes waiting in the outbox.
+func (s *Store) Queued() int {
+	return s.box.Len()
+}
+
+// Breaker returns the breaker, e.g. for logging its state.
+func (s *Store) Breaker() *breaker.Breaker {
+	return s.breaker
+}
+
+// Run probes the store while the breaker is open and delivers queued writes
+// while it is closed, until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		if s.cfg.Active == nil || s.cfg.Active() {
+			recovered := s.breaker.ProbeDue() && s.breaker.Probe(ctx, s.probe)
+			if s.breaker.Allow() && s.box.Len() > 0 {
+				s.drain(ctx)
+			}
+			if recovered && s.breaker.Allow() && s.cfg.OnRecovered != nil {
+				s.cfg.OnRecovered()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks whether the store is back: with Ping if it has one, otherwise
+// by delivering the oldest queued write.
+func (s *Store) probe(ctx context.Context) error {
+	if p, ok := s.inner.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	w, ok := s.box.Peek()
+	if !ok {
+		return nil
+	}
+	err := s.deliver(ctx, w)
+	if pagestore.IsConflict(err) {
+		s.logChanged(ctx, w)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.box.Remove(w.Seq)
+}
+
+// drain delivers queued writes oldest first until the outbox is empty, an
+// outage error occurs or ctx is done. Writes the store rejects for good are
+// dropped, so one bad page cannot block the queue.
+func (s *Store) drain(ctx context.Context) {
+	delivered, dropped := 0, 0
+	defer func() {
+		if delivered+dropped > 0 {
+			s.logger.Info("outbox drained", "delivered", delivered, "dropped", dropped, "queued", s.box.Len())
+		}
+	}()
+	for ctx.Err() == nil && s.breaker.Allow() {
+		s.writeMu.Lock()
+		w, ok := s.box.Peek()
+		if !ok {
+			s.writeMu.Unlock()
+			return
+		}
+		err := s.deliver(ctx, w)
+		s.record(err)
+		switch {
+		case err == nil:
+			delivered++
+		case ctx.Err() != nil || s.outage(err):
+			s.writeMu.Unlock()
+			return
+		case pagestore.IsConflict(err):
+			dropped++
+			s.logChanged(ctx, w)
+		default:
+			dropped++
+			s.logger.ErrorContext(logging.With(ctx, logging.KeyPage, w.Title), "outbox: dropping undeliverable write",
+				"queued_at", w.QueuedAt, "error", err)
+		}
+		err = s.box.Remove(w.Seq)
+		s.writeMu.Unlock()
+		if err != nil {
+			s.logger.Error("outbox: remove delivered write", "error", err)
+			return
+		}
+	}
+}
+
+func (s *Store) logChanged(ctx context.Context, w Write) {
+	s.logger.WarnContext(logging.With(ctx, logging.KeyPage, w.Title), "outbox: page changed since the write was queued, dropping it",
+		"queued_at", w.QueuedAt, "base_revision", w.BaseRevision)
+}
+
+// deliver sends w to the store. A write based on a known revision fails with
+// pagestore.ErrConflict if the page has changed since.
+func (s *Store) deliver(ctx context.Context, w Write) error {
+	if w.Delete {
+		if w.BaseRevision != 0 || w.Missing {
+			// deletions cannot be made conditional; check the page first
+			page, err := s.inner.Get(ctx, w.Title)
+			switch {
+			case pagestore.IsNotFound(err):
+				return nil
+			case err != nil:
+				return err
+			case w.Missing || page.Revision.ID != w.BaseRevision:
+				return fmt.Errorf("%w: %s changed since revision %d", pagestore.ErrConflict, w.Title, w.BaseRevision)
+			}
+		}
+		return s.inner.Delete(ctx, w.Title, w.Summary)
+	}
+	return s.inner.Put(ctx, w.Title, w.Content, pagestore.PutOptions{
+		Summary:      w.Summary,
+		Bot:          w.Bot,
+		BaseRevision: w.BaseRevision,
+		CreateOnly:   w.Missing,
+	})
+}
+
+// seen remembers the revision of title as read from the store; page is nil
+// for a missing page.
+func (s *Store) seen(title string, page *pagestore.Page) {
+	var rev int64
+	if page != nil {
+		rev = page.Revision.ID
+	}
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+	s.revisions[pagestore.NormalizeTitle(title)] = rev
+}
+
+// forgetRevision drops the remembered revision of title, e.g. after writing
+// the page made it stale.
+func (s *Store) forgetRevision(title string) {
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+	delete(s.revisions, pagestore.NormalizeTitle(title))
+}
+
+// base sets the revision w is based on: that of a write already queued for
+// the page, or else the one last read. It reports false if neither is known.
+func (s *Store) base(w *Write) bool {
+	if q, ok := s.box.Lookup(w.Title); ok {
+		w.BaseRevision, w.Missing = q.BaseRevision, q.Missing
+		return true
+	}
+	s.revMu.Lock()
+	defer s.revMu.Unlock()
+	rev, ok := s.revisions[pagestore.NormalizeTitle(w.Title)]
+	w.BaseRevision, w.Missing = rev, ok && rev == 0
+	return ok
+}
+
+// outage reports whether err means the store cannot take writes for now.
+func (s *Store) outage(err error) bool {
+	return pagestore.IsReadOnly(err) || s.cfg.Outage(err)
+}
+
+// record feeds the outcome of a write to the breaker. Reads are not
+// recorded: a read-only wiki serves them fine. Missing pages and lost races
+// are answers, not outages.
+func (s *Store) record(err error) {
+	switch {
+	case err == nil:
+		s.breaker.Success()
+	case pagestore.IsReadOnly(err):
+		s.breaker.Trip(err)
+	case s.cfg.Outage(err):
+		s.breaker.Failure(err)
+	default:
+		s.breaker.Success()
+	}
+}
+
+func unavailable(title string) error {
+	return fmt.Errorf("%w: %s: circuit open", ErrUnavailable, title)
+}
+
+// queuedPage turns a queued edit into the page a read returns.
+func queuedPage(w Write) *pagestore.Page {
+	return &pagestore.Page{
+		Title:    w.Title,
+		Content:  w.Content,
+		Revision: pagestore.Revision{Timestamp: w.QueuedAt, Summary: w.Summary, Bot: w.Bot},
+	}
+}
+
+// Get implements pagestore.PageStore.
+func (s *Store) Get(ctx context.Context, title string) (*pagestore.Page, error) {
+	if w, ok := s.box.Lookup(title); ok {
+		if w.Delete {
+			return nil, fmt.Errorf("%w: %s", pagestore.ErrNotFound, title)
+		}
+		return queuedPage(w), nil
+	}
+	if !s.breaker.Allow() {
+		return nil, unavailable(title)
+	}
+	page, err := s.inner.Get(ctx, title)
+	switch {
+	case err == nil:
+		s.seen(title, page)
+	case pagestore.IsNotFound(err):
+		s.seen(title, nil)
+	}
+	return page, err
+}
+
+// GetMany implements pagestore.PageStore.
+func (s *Store) GetMany(ctx context.Context, titles []string) (map[string]*pagestore.Page, error) {
+	if !s.breaker.Allow() {
+		return nil, unavailable(fmt.Sprintf("%d pages", len(titles)))
+	}
+	pages, err := s.inner.GetMany(ctx, titles)
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		s.seen(title, pages[title])
+		w, ok := s.box.Lookup(title)
+		switch {
+		case !ok:
+		case w.Delete:
+			delete(pages, title)
+		default:
+			pages[title] = queuedPage(w)
+		}
+	}
+	return pages, nil
+}
+
+// List implements pagestore.PageStore.
+func (s *Store) List(ctx context.Context, prefix string) ([]string, error) {
+	if !s.breaker.Allow() {
+		return nil, unavailable(prefix + "*")
+	}
+	titles, err := s.inner.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	pending := s.box.Pending()
+	if len(pending) == 0 {
+		return titles, nil
+	}
+	prefix = pagestore.NormalizeTitle(prefix)
+	for _, w := range pending {
+		title := pagestore.NormalizeTitle(w.Title)
+		if !strings.HasPrefix(title, prefix) {
+			continue
+		}
+		titles = slices.DeleteFunc(titles, func(t string) bool { return samePage(t, title) })
+		if !w.Delete {
+			titles = append(titles, title)
+		}
+	}
+	slices.Sort(titles)
+	return titles, nil
+}
+
+// Put implements pagestore.PageStore. Conditional writes are never queued:
+// their precondition would be meaningless by the time they are delivered.
+func (s *Store) Put(ctx context.Context, title, content string, opts pagestore.PutOptions) error {
+	if opts.BaseRevision != 0 || opts.CreateOnly {
+		if !s.breaker.Allow() {
+			return unavailable(title)
+		}
+		err := s.inner.Put(ctx, title, content, opts)
+		s.record(err)
+		return err
+	}
+	return s.write(ctx, Write{Title: title, Content: content, Summary: opts.Summary, Bot: opts.Bot})
+}
+
+// Delete implements pagestore.PageStore.
+func (s *Store) Delete(ctx context.Context, title, reason string) error {
+	return s.write(ctx, Write{Title: title, Delete: true, Summary: reason})
+}
+
+// write delivers w directly while the breaker is closed and queues it when
+// the breaker is open or the delivery failed because of an outage.
+func (s *Store) write(ctx context.Context, w Write) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	ctx = logging.With(ctx, logging.KeyPage, w.Title)
+	if s.breaker.Allow() {
+		err := s.deliver(ctx, w)
+		s.record(err)
+		if err == nil {
+			s.forgetRevision(w.Title)
+			// anything still queued for the page is older
+			return s.box.Forget(w.Title)
+		}
+		if !s.outage(err) || ctx.Err() != nil {
+			return err
+		}
+		if !s.base(&w) {
+			return err
+		}
+		s.logger.WarnContext(ctx, "wiki write failed, queueing it", "error", err)
+	} else if !s.base(&w) {
+		return unavailable(w.Title)
+	}
+	superseded, err := s.box.Add(w)
+	if err != nil {
+		return fmt.Errorf("queue write of %s: %w", w.Title, err)
+	}
+	s.logger.InfoContext(ctx, "wiki write queued", "delete", w.Delete, "superseded", superseded, "queued", s.box.Len())
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package retry retries requests that failed transiently, with exponential
+// backoff and jitter. Errors are classified by the code that made the
+// request: Transient marks an error as worth retrying and records whether the
+// request could have had an effect, so non-idempotent requests (conditional
+// edits, logins) are only repeated when the server never acted on them.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default policy values.
+const (
+	DefaultMaxAttempts = 4
+	DefaultMinDelay    = 500 * time.Millisecond
+	DefaultMaxDelay    = 30 * time.Second
+)
+
+// Policy configures retries. The zero value uses the defaults.
+type Policy struct {
+	// MaxAttempts is the total number of attempts; 1 disables retries.
+	MaxAttempts int
+	// MinDelay is the delay bound of the first retry; it doubles with every
+	// further retry up to MaxDelay. The actual delay is jittered between
+	// half and all of the bound.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// Notify is called before waiting to retry: attempt failed with err and the
+// next one starts after delay.
+type Notify func(err error, attempt int, delay time.Duration)
+
+// Error is a transient failure.
+type Error struct {
+	Err error
+	// Unsent means the request had no effect: it never reached the server or
+	// the server refused it (overloaded, rate limited, read-only). Such
+	// requests are retried even when they are not idempotent.
+	Unsent bool
+	// After is the delay the server asked for with Retry-After, if any.
+	After time.Duration
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Transient marks err as a transient failure. It returns nil for nil.
+func Transient(err error, unsent bool, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Err: err, Unsent: unsent, After: after}
+}
+
+// Retryable reports whether a request that failed with err may be repeated;
+// idempotent requests may be repeated even when they could have had an
+// effect.
+func Retryable(err error, idempotent bool) bool {
+	var e *Error
+	return errors.As(err, &e) && (idempotent || e.Unsent)
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.MinDelay <= 0 {
+		p.MinDelay = DefaultMinDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+	p.MaxDelay = max(p.MaxDelay, p.MinDelay)
+	return p
+}
+
+// Delay returns the jittered delay after the given failed attempt (1-based).
+func (p Policy) Delay(attempt int) time.Duration {
+	p = p.withDefaults()
+	bound := p.MinDelay
+	for i := 1; i < attempt && bound < p.MaxDelay; i++ {
+		bound *= 2
+	}
+	bound = min(bound, p.MaxDelay)
+	return bound/2 + rand.N(bound/2+1)
+}
+
+// Do calls op until it succeeds, fails with an error that may not be
+// retried, the attempts are used up or ctx is done, and returns op's last
+// error. A server asking to wait longer than MaxDelay is not retried: the
+// caller is better off moving on. notify may be nil.
+func (p Policy) Do(ctx context.Context, idempotent bool, op func() error, notify Notify) error {
+	p = p.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !Retryable(err, idempotent) {
+			return err
+		}
+		delay := p.Delay(attempt)
+		var e *Error
+		if errors.As(err, &e) && e.After > 0 {
+			if e.After > p.MaxDelay {
+				return err
+			}
+			delay = max(delay, e.After)
+		}
+		if notify != nil {
+			notify(err, attempt, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// RequestError classifies an error of http.Client.Do. Requests that could not
+// connect are unsent; timeouts and broken connections may have reached the
+// server. Cancellation is not retried.
+func RequestError(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	unsent := (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr)
+	return Transient(err, unsent, 0)
+}
+
+// StatusError classifies err, the failure of a request that got resp. 429
+// and 503 mean the server refused the request; 408, 500, 502 and 504 may
+// have come from a proxy after the server acted on it. Other statuses return
+// err unchanged.
+func StatusError(resp *http.Response, err error) error {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return Transient(err, true, RetryAfter(resp.Header))
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return Transient(err, false, RetryAfter(resp.Header))
+	}
+	return err
+}
+
+// RetryAfter parses a Retry-After header given in seconds or as an HTTP
+// date; it returns 0 when the header is absent or invalid.
+func RetryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
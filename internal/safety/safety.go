@@ -0,0 +1,311 @@
+// Package safety stops syncs that would change an abnormal share of the
+// wiki, e.g. because VPMM served a truncated index or a rendering bug changed
+// every page. A tripped Guard refuses all further syncs until the connector is
+// restarted with the override flag; the trip is persisted, so a plain restart
+// does not clear it. So are the index sizes the next run is compared to.
+package safety
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/atomicfile"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// Default limits.
+const (
+	DefaultMaxEdits            = 1000
+	DefaultMaxEditsPercent     = 50
+	DefaultMaxDeletions        = 100
+	DefaultMaxDeletionsPercent = 10
+	DefaultMaxIndexDropPercent = 20
+)
+
+// Limits are the guardrails of one sync run. Zero disables a limit.
+type Limits struct {
+	// MaxEdits and MaxDeletions cap the page writes of one run.
+	MaxEdits     int
+	MaxDeletions int
+	// MaxEditsPercent and MaxDeletionsPercent cap them relative to the
+	// number of managed pages. They do not apply while the wiki has none.
+	MaxEditsPercent     int
+	MaxDeletionsPercent int
+	// MaxIndexDropPercent is how far the number of packages in the index may
+	// fall compared to the last index that passed the check.
+	MaxIndexDropPercent int
+}
+
+// ErrTripped is matched by every *Trip.
+var ErrTripped = errors.New("safety limit exceeded")
+
+// Trip records why a Guard stopped syncing.
+type Trip struct {
+	Reason string    `json:"reason"`
+	RunID  string    `json:"runId,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+func (t *Trip) Error() string { return "safety limit exceeded: " + t.Reason }
+
+// Is makes errors.Is(err, ErrTripped) match.
+func (t *Trip) Is(target error) bool { return target == ErrTripped }
+
+// state is the content of the guard's file.
+type state struct {
+	Trip *Trip `json:"trip,omitempty"`
+	// Sources maps source names to the package count of their last listing
+	// that passed CheckIndex.
+	Sources map[string]int `json:"sources,omitempty"`
+}
+
+// Guard enforces Limits across runs. It is safe for concurrent use.
+type Guard struct {
+	limits Limits
+	path   string
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	trip     *Trip
+	sources  map[string]int
+	override bool
+}
+
+// NewGuard returns a Guard enforcing limits. When path is set, a trip and
+// the index sizes of the sources are saved there and loaded again, keeping
+// the guard tripped across restarts. override clears a saved trip and lifts
+// the limits for the next run only.
+func NewGuard(limits Limits, path string, override bool, logger *slog.Logger) (*Guard, error) {
+	g := &Guard{limits: limits, path: path, logger: logging.OrDiscard(logger), override: override, sources: map[string]int{}}
+	if path == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return g, nil
+	case err != nil:
+		return nil, fmt.Errorf("read safety state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("decode safety state %s: %w", path, err)
+	}
+	if st.Sources != nil {
+		g.sources = st.Sources
+	}
+	g.trip = st.Trip
+	if g.trip == nil || !override {
+		return g, nil
+	}
+	g.logger.Warn("safety override: clearing tripped guard", "reason", g.trip.Reason, "tripped_at", g.trip.At)
+	g.trip = nil
+	if err := g.saveLocked(); err != nil {
+		return nil, fmt.Errorf("clear safety trip: %w", err)
+	}
+	return g, nil
+}
+
+// Tripped returns the trip that stopped syncing, or nil.
+func (g *Guard) Tripped() *Trip {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.trip
+}
+
+// Overridden reports whether the limits are lifted for the current run.
+func (g *Guard) Overridden() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.override
+}
+
+// EndRun ends the run the override applied to.
+func (g *Guard) EndRun() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.override = false
+}
+
+// CheckIndex trips the guard when the package count of the sources in
+// counts, which maps the names of the sources that returned a listing to its
+// size, fell by more than MaxIndexDropPercent since the last check that
+// passed. Sources without an earlier count are not compared, nor are sources
+// missing from counts, e.g. because they failed. The counts of a passing
+// check are saved.
+func (g *Guard) CheckIndex(ctx context.Context, counts map[string]int) error {
+	g.mu.Lock()
+	prev, next := 0, 0
+	for name, n := range counts {
+		if p, ok := g.sources[name]; ok {
+			prev += p
+			next += n
+		}
+	}
+	g.mu.Unlock()
+	if limit := g.limits.MaxIndexDropPercent; limit > 0 && prev > 0 && next < prev {
+		if drop := (prev - next) * 100 / prev; drop > limit {
+			reason := fmt.Sprintf("index shrank from %d to %d packages (-%d%%, limit %d%%)", prev, next, drop, limit)
+			if err := g.tripOrOverride(ctx, reason); err != nil {
+				return err
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	changed := false
+	for name, n := range counts {
+		if p, ok := g.sources[name]; !ok || p != n {
+			g.sources[name] = n
+			changed = true
+		}
+	}
+	if changed {
+		if err := g.saveLocked(); err != nil {
+			g.logger.ErrorContext(ctx, "save safety state", "error", err)
+		}
+	}
+	return nil
+}
+
+// Budget returns a PageStore wrapping inner that allows the edits and
+// deletions of one run and trips the guard instead of exceeding them.
+// managed is the number of pages the connector manages.
+func (g *Guard) Budget(inner pagestore.PageStore, managed int) *Budget {
+	return &Budget{
+		PageStore:    inner,
+		guard:        g,
+		maxEdits:     resolve(g.limits.MaxEdits, g.limits.MaxEditsPercent, managed),
+		maxDeletions: resolve(g.limits.MaxDeletions, g.limits.MaxDeletionsPercent, managed),
+	}
+}
+
+// resolve returns the stricter of the absolute and the relative limit, or 0
+// if neither applies.
+func resolve(absolute, percent, managed int) int {
+	limit := absolute
+	if percent > 0 && managed > 0 {
+		rel := max(managed*percent/100, 1)
+		if limit <= 0 || rel < limit {
+			limit = rel
+		}
+	}
+	return max(limit, 0)
+}
+
+// tripOrOverride trips the guard, unless the override is active, in which
+// case the violation is only logged.
+func (g *Guard) tripOrOverride(ctx context.Context, reason string) error {
+	g.mu.Lock()
+	if g.override {
+		g.mu.Unlock()
+		g.logger.WarnContext(ctx, "safety limit exceeded, proceeding because of the override", "reason", reason)
+		return nil
+	}
+	if g.trip != nil {
+		trip := g.trip
+		g.mu.Unlock()
+		return trip
+	}
+	trip := &Trip{Reason: reason, RunID: logging.Value(ctx, logging.KeySyncRun), At: time.Now().UTC()}
+	g.trip = trip
+	err := g.saveLocked()
+	g.mu.Unlock()
+
+	g.logger.ErrorContext(ctx, "SAFETY LIMIT EXCEEDED: sync aborted and stopped until restarted with the override flag",
+		"reason", reason)
+	if err != nil {
+		g.logger.ErrorContext(ctx, "save safety trip", "error", err)
+	}
+	return trip
+}
+
+// saveLocked writes the trip and the index sizes to the guard's path
+// atomically.
+func (g *Guard) saveLocked() error {
+	if g.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state{Trip: g.trip, Sources: g.sources}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode safety state: %w", err)
+	}
+	if err := atomicfile.WriteFile(g.path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write safety state: %w", err)
+	}
+	return nil
+}
+
+// Budget counts the writes of one run; see Guard.Budget. Reads pass through.
+type Budget struct {
+	pagestore.PageStore
+	guard        *Guard
+	maxEdits     int
+	maxDeletions int
+
+	mu        sync.Mutex
+	edits     int
+	deletions int
+}
+
+// Counts returns the edits and deletions made through the budget.
+func (b *Budget) Counts() (edits, deletions int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.edits, b.deletions
+}
+
+// Put implements pagestore.PageStore.
+func (b *Budget) Put(ctx context.Context, title, content string, opts pagestore.PutOptions) error {
+	if err := b.take(ctx, &b.edits, b.maxEdits, "edits"); err != nil {
+		return err
+	}
+	err := b.PageStore.Put(ctx, title, content, opts)
+	if err != nil {
+		b.refund(&b.edits)
+	}
+	return err
+}
+
+// Delete implements pagestore.PageStore.
+func (b *Budget) Delete(ctx context.Context, title, reason string) error {
+	if err := b.take(ctx, &b.deletions, b.maxDeletions, "deletions"); err != nil {
+		return err
+	}
+	err := b.PageStore.Delete(ctx, title, reason)
+	if err != nil {
+		b.refund(&b.deletions)
+	}
+	return err
+}
+
+// take counts one write against limit. The write that would exceed it trips
+// the guard instead, unless the override is active.
+func (b *Budget) take(ctx context.Context, count *int, limit int, kind string) error {
+	if trip := b.guard.Tripped(); trip != nil {
+		return trip
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limit > 0 && *count == limit {
+		if err := b.guard.tripOrOverride(ctx, fmt.Sprintf("run would make more than %d %s", limit, kind)); err != nil {
+			return err
+		}
+	}
+	*count++
+	return nil
+}
+
+func (b *Budget) refund(count *int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*count--
+}
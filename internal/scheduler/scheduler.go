@@ -0,0 +1,345 @@
+// Package scheduler decides when the connector runs a sync. Triggers (SSE
+// events, polls, reconciliations) are coalesced into one pending batch; a run
+// becomes due after a quiet period without new triggers, but never later than
+// a maximum wait after the first one, and never sooner than a minimum interval
+// after the previous run started.
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/scheduler")
+
+// maxLinks caps the span links kept on the debounce span, so a flood of
+// triggers cannot grow memory without bound.
+const maxLinks = 64
+
+// Default timings.
+const (
+	DefaultQuiet       = 30 * time.Second
+	DefaultMaxWait     = 5 * time.Minute
+	DefaultMinInterval = time.Minute
+)
+
+// Priority orders packages within a run; higher runs first.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	// PriorityHigh is used for stable releases, which users wait for.
+	PriorityHigh
+	// PriorityResume is used for packages an interrupted run left behind.
+	PriorityResume
+)
+
+// Trigger asks for a sync.
+type Trigger struct {
+	// Reason names the trigger in traces, e.g. the SSE event name.
+	Reason string
+	// Package, if set, is processed by the next run even if its listing
+	// looks unchanged.
+	Package  string
+	Priority Priority
+	// Full makes the next run process every package.
+	Full bool
+	// Urgent skips the quiet period; the minimum interval still applies.
+	Urgent bool
+	// Span is the span that received the trigger, linked from the debounce span.
+	Span trace.SpanContext
+}
+
+// Config holds the scheduler timings. Zero values select the defaults.
+type Config struct {
+	// Quiet is how long triggers must pause before a run starts.
+	Quiet time.Duration
+	// MaxWait caps how long the first pending trigger waits for its run.
+	MaxWait time.Duration
+	// MinInterval is the least time between the starts of two runs.
+	MinInterval time.Duration
+}
+
+// Batch is the work handed to one run.
+type Batch struct {
+	Full bool
+	// Packages maps the packages named by triggers to their priority.
+	Packages map[string]Priority
+	// Triggers counts the triggers coalesced into the batch.
+	Triggers int
+	// Waited is the time from the first trigger until the run started.
+	Waited time.Duration
+	// Debounce is the span covering that wait; zero if tracing is off.
+	Debounce trace.SpanContext
+}
+
+// State is a snapshot of the scheduler for logs and diagnostics.
+type State struct {
+	Pending         bool
+	PendingFull     bool
+	PendingPackages int
+	PendingTriggers int
+	FirstTrigger    time.Time
+	LastTrigger     time.Time
+	// NextRun is when the pending batch becomes due; zero while idle or running.
+	NextRun      time.Time
+	Running      bool
+	LastRunStart time.Time
+	LastRunEnd   time.Time
+	Runs         int
+}
+
+// LogValue implements slog.LogValuer.
+func (st State) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.Bool("pending", st.Pending),
+		slog.Bool("running", st.Running),
+		slog.Int("runs", st.Runs),
+	}
+	if st.Pending {
+		attrs = append(attrs,
+			slog.Bool("pending_full", st.PendingFull),
+			slog.Int("pending_packages", st.PendingPackages),
+			slog.Int("pending_triggers", st.PendingTriggers),
+			slog.Time("first_trigger", st.FirstTrigger),
+		)
+	}
+	if !st.NextRun.IsZero() {
+		attrs = append(attrs, slog.Time("next_run", st.NextRun))
+	}
+	if !st.LastRunStart.IsZero() {
+		attrs = append(attrs, slog.Time("last_run_start", st.LastRunStart))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Scheduler coalesces triggers and signals Ready when a run is due. Add never
+// blocks. Only one run is handed out at a time: triggers arriving during a
+// run form the next batch.
+type Scheduler struct {
+	cfg   Config
+	ready chan struct{}
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	full     bool
+	urgent   bool
+	packages map[string]Priority
+	triggers int
+	first    time.Time
+	last     time.Time
+	next     time.Time
+	running  bool
+	runStart time.Time
+	runEnd   time.Time
+	runs     int
+	debounce trace.Span
+}
+
+// New returns an idle Scheduler.
+func New(cfg Config) *Scheduler {
+	if cfg.Quiet <= 0 {
+		cfg.Quiet = DefaultQuiet
+	}
+	if cfg.MaxWait < cfg.Quiet {
+		cfg.MaxWait = max(DefaultMaxWait, cfg.Quiet)
+	}
+	if cfg.MinInterval < 0 {
+		cfg.MinInterval = 0
+	}
+	s := &Scheduler{
+		cfg:      cfg,
+		ready:    make(chan struct{}, 1),
+		packages: map[string]Priority{},
+	}
+	s.timer = time.AfterFunc(time.Hour, s.fire)
+	s.timer.Stop()
+	return s
+}
+
+// Ready is signalled when a batch is due; call Take to claim it.
+func (s *Scheduler) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Add records t and reschedules the pending run.
+func (s *Scheduler) Add(ctx context.Context, t Trigger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.triggers == 0 {
+		s.first = now
+		_, s.debounce = tracer.Start(context.WithoutCancel(ctx), "sync.debounce")
+	}
+	s.triggers++
+	s.last = now
+	s.full = s.full || t.Full
+	s.urgent = s.urgent || t.Urgent
+	if t.Package != "" {
+		if p, ok := s.packages[t.Package]; !ok || t.Priority > p {
+			s.packages[t.Package] = t.Priority
+		}
+	}
+	if t.Span.IsValid() && s.triggers <= maxLinks {
+		s.debounce.AddLink(trace.Link{SpanContext: t.Span, Attributes: []attribute.KeyValue{
+			attribute.String("sync.trigger", t.Reason),
+			attribute.String("vpm.package", t.Package),
+		}})
+	}
+	s.rescheduleLocked(now)
+}
+
+// rescheduleLocked arms the timer for the pending batch.
+func (s *Scheduler) rescheduleLocked(now time.Time) {
+	if s.running || s.triggers == 0 {
+		s.timer.Stop()
+		s.next = time.Time{}
+		return
+	}
+	due := minTime(s.last.Add(s.cfg.Quiet), s.first.Add(s.cfg.MaxWait))
+	if s.urgent {
+		due = now
+	}
+	if !s.runStart.IsZero() {
+		due = maxTime(due, s.runStart.Add(s.cfg.MinInterval))
+	}
+	s.next = due
+	s.timer.Reset(max(due.Sub(now), 0))
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (s *Scheduler) fire() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take claims the pending batch and marks a run as started. It reports false
+// when nothing is due, e.g. for a stale Ready signal.
+func (s *Scheduler) Take() (Batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.running || s.triggers == 0 || now.Before(s.next) {
+		return Batch{}, false
+	}
+	b := s.claimLocked(now)
+	s.running = true
+	s.runStart = now
+	s.runs++
+	return b, true
+}
+
+// Flush claims the pending batch regardless of its due time without starting
+// a run, e.g. to checkpoint it on shutdown. It reports false when nothing is
+// pending.
+func (s *Scheduler) Flush() (Batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.triggers == 0 {
+		return Batch{}, false
+	}
+	b := s.claimLocked(time.Now())
+	s.timer.Stop()
+	return b, true
+}
+
+// claimLocked returns the pending batch and resets it.
+func (s *Scheduler) claimLocked(now time.Time) Batch {
+	b := Batch{
+		Full:     s.full,
+		Packages: s.packages,
+		Triggers: s.triggers,
+		Waited:   now.Sub(s.first),
+	}
+	if s.debounce != nil {
+		s.debounce.SetAttributes(attribute.Int("sync.triggers", s.triggers))
+		s.debounce.End()
+		b.Debounce = s.debounce.SpanContext()
+		s.debounce = nil
+	}
+	s.full, s.urgent = false, false
+	s.packages = map[string]Priority{}
+	s.triggers = 0
+	s.first, s.last, s.next = time.Time{}, time.Time{}, time.Time{}
+	return b
+}
+
+// Done marks the current run as finished and schedules the batch that
+// accumulated meanwhile, if any.
+func (s *Scheduler) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.running = false
+	s.runEnd = now
+	s.rescheduleLocked(now)
+}
+
+// Stop cancels the pending timer.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer.Stop()
+	if s.debounce != nil {
+		s.debounce.End()
+		s.debounce = nil
+	}
+}
+
+// State returns a snapshot of the scheduler.
+func (s *Scheduler) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return State{
+		Pending:         s.triggers > 0,
+		PendingFull:     s.full,
+		PendingPackages: len(s.packages),
+		PendingTriggers: s.triggers,
+		FirstTrigger:    s.first,
+		LastTrigger:     s.last,
+		NextRun:         s.next,
+		Running:         s.running,
+		LastRunStart:    s.runStart,
+		LastRunEnd:      s.runEnd,
+		Runs:            s.runs,
+	}
+}
+
+// Order returns the names of packages sorted by descending priority, then by
+// name.
+func Order(packages map[string]Priority) []string {
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := packages[names[i]], packages[names[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
@@ -0,0 +1,169 @@
+// Package secrets loads credentials from the environment or mounted secret
+// files and keeps them out of logs and error messages.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "[REDACTED]"
+
+// minSecretLen is the shortest value the Redactor replaces; shorter values
+// would mangle unrelated text.
+const minSecretLen = 4
+
+// sensitiveKeys are substrings of attribute keys whose values are always redacted.
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "lgpassword"}
+
+// Lookup returns the value of the environment variable name. When
+// name+"_FILE" is set, the value is read from that file instead (Docker and
+// Kubernetes secrets) with surrounding whitespace trimmed.
+func Lookup(name string) (string, error) {
+	if path := strings.TrimSpace(os.Getenv(name + "_FILE")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read %s_FILE: %w", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return os.Getenv(name), nil
+}
+
+// IsSensitiveKey reports whether a log attribute or parameter key names a credential.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor replaces registered secret values in strings, errors and log output.
+// The zero value is ready to use.
+type Redactor struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// Set replaces the registered secrets. Empty and very short values are ignored.
+func (r *Redactor) Set(values ...string) {
+	var keep []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) >= minSecretLen && !slices.Contains(keep, v) {
+			keep = append(keep, v)
+		}
+	}
+	// replace longer secrets first so a secret containing another is fully hidden
+	slices.SortFunc(keep, func(a, b string) int { return len(b) - len(a) })
+	r.mu.Lock()
+	r.values = keep
+	r.mu.Unlock()
+}
+
+// Redact returns s with every registered secret replaced by Placeholder.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, Placeholder)
+	}
+	return s
+}
+
+// redactedError carries a redacted message while still unwrapping to the original.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Error returns err with secrets removed from its message. errors.Is/As still
+// see the original error.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if red := r.Redact(msg); red != msg {
+		return &redactedError{msg: red, err: err}
+	}
+	return err
+}
+
+// ReplaceAttr is a slog.HandlerOptions.ReplaceAttr that redacts attributes
+// with sensitive keys and registered secrets in their values. LogValuers are
+// resolved and groups walked first, so nested attributes are redacted too;
+// other values whose formatted text contains a secret are replaced by the
+// redacted text.
+func (r *Redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, Placeholder)
+	}
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.Redact(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		red := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			red[i] = r.ReplaceAttr(nil, ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(red...)}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, r.Redact(err.Error()))
+		}
+		if text := fmt.Sprintf("%+v", a.Value.Any()); r.Redact(text) != text {
+			return slog.String(a.Key, r.Redact(text))
+		}
+	}
+	return a
+}
+
+// Handler wraps h so that the log message and all attributes pass through the Redactor.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return &redactingHandler{r: r, next: h}
+}
+
+type redactingHandler struct {
+	r    *Redactor
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.Redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.r.ReplaceAttr(nil, a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	red := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		red[i] = h.r.ReplaceAttr(nil, a)
+	}
+	return &redactingHandler{r: h.r, next: h.next.WithAttrs(red)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{r: h.r, next: h.next.WithGroup(name)}
+}
@@ -0,0 +1,215 @@
+package testsupport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeSSEEvent is an event published by FakeVPMM.
+type FakeSSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// FakeVPMM is an httptest-based fake of a VPMM server. It serves /index.json
+// from a settable document (with ETag/Last-Modified and 304 responses) and /sse as a text/event-stream that replays
+// events after Last-Event-ID and then streams newly published events.
+type FakeVPMM struct {
+	Server *httptest.Server
+
+	mu          sync.Mutex
+	index       []byte
+	indexStatus int
+	indexTime   time.Time
+	indexHits   int
+	events      []FakeSSEEvent
+	subscribers map[chan FakeSSEEvent]struct{}
+	nextID      int
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewFakeVPMM starts a FakeVPMM serving an empty index. Call Close when done.
+func NewFakeVPMM() *FakeVPMM {
+	v := &FakeVPMM{
+		index:       []byte(`{"packages":{}}`),
+		indexStatus: http.StatusOK,
+		indexTime:   time.Now().UTC().Truncate(time.Second),
+		subscribers: make(map[chan FakeSSEEvent]struct{}),
+		done:        make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.json", v.serveIndex)
+	mux.HandleFunc("/sse", v.serveSSE)
+	v.Server = httptest.NewServer(mux)
+	return v
+}
+
+// URL returns the base URL of the fake.
+func (v *FakeVPMM) URL() string {
+	return v.Server.URL
+}
+
+// Close ends all SSE streams and shuts the server down.
+func (v *FakeVPMM) Close() {
+	v.closeOnce.Do(func() { close(v.done) })
+	v.Server.Close()
+}
+
+// SetIndex replaces the /index.json document with the JSON encoding of listing.
+func (v *FakeVPMM) SetIndex(listing any) error {
+	data, err := json.Marshal(listing)
+	if err != nil {
+		return fmt.Errorf("encode index: %w", err)
+	}
+	v.SetIndexJSON(data)
+	return nil
+}
+
+// SetIndexJSON replaces the /index.json document.
+func (v *FakeVPMM) SetIndexJSON(data []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.index = data
+	v.indexStatus = http.StatusOK
+	v.indexTime = time.Now().UTC().Truncate(time.Second)
+}
+
+// IndexDownloads returns how often /index.json was served with a full body.
+func (v *FakeVPMM) IndexDownloads() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.indexHits
+}
+
+// SetIndexStatus makes /index.json answer with the given status and an
+// application/problem+json body until the next SetIndex call.
+func (v *FakeVPMM) SetIndexStatus(status int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.indexStatus = status
+}
+
+// Publish sends an event with a JSON-encoded payload to all SSE subscribers
+// and keeps it for replay. It returns the assigned event ID.
+func (v *FakeVPMM) Publish(event string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("encode event: %w", err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.nextID++
+	ev := FakeSSEEvent{ID: strconv.Itoa(v.nextID), Event: event, Data: string(data)}
+	v.events = append(v.events, ev)
+	for ch := range v.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// slow subscriber; it will see the event on replay after reconnecting
+		}
+	}
+	return ev.ID, nil
+}
+
+// Subscribers returns the number of connected SSE clients.
+func (v *FakeVPMM) Subscribers() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.subscribers)
+}
+
+func (v *FakeVPMM) serveIndex(rw http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	data, status, modified := v.index, v.indexStatus, v.indexTime
+	v.mu.Unlock()
+
+	if status != http.StatusOK {
+		rw.Header().Set("Content-Type", "application/problem+json")
+		rw.WriteHeader(status)
+		_ = json.NewEncoder(rw).Encode(map[string]any{"title": http.StatusText(status), "status": status, "detail": "injected failure"})
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	v.mu.Lock()
+	v.indexHits++
+	v.mu.Unlock()
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(data)
+}
+
+func writeSSE(rw http.ResponseWriter, ev FakeSSEEvent) error {
+	_, err := fmt.Fprintf(rw, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Event, ev.Data)
+	return err
+}
+
+func (v *FakeVPMM) serveSSE(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan FakeSSEEvent, 64)
+
+	v.mu.Lock()
+	var replay []FakeSSEEvent
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		for i, ev := range v.events {
+			if ev.ID == last {
+				replay = append(replay, v.events[i+1:]...)
+				break
+			}
+		}
+	}
+	v.subscribers[ch] = struct{}{}
+	v.mu.Unlock()
+	defer func() {
+		v.mu.Lock()
+		delete(v.subscribers, ch)
+		v.mu.Unlock()
+	}()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	for _, ev := range replay {
+		if writeSSE(rw, ev) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-v.done:
+			return
+		case ev := <-ch:
+			if writeSSE(rw, ev) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
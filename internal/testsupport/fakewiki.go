@@ -0,0 +1,512 @@
+// Package testsupport provides in-memory fakes of the external services the
+// connector talks to, for use in tests: a MediaWiki action API (FakeWiki)
+// and a VPMM server serving /index.json and /sse (FakeVPMM).
+package testsupport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionCookie is the cookie FakeWiki uses to track logged-in sessions.
+const sessionCookie = "fakewiki_session"
+
+// namespaceIDs maps the namespace prefixes FakeWiki understands to their IDs.
+var namespaceIDs = map[string]int{
+	"Template": 10,
+	"Project":  4,
+	"User":     2,
+}
+
+// FakeWikiPage is a page stored by FakeWiki.
+type FakeWikiPage struct {
+	Title     string
+	Content   string
+	RevID     int64
+	Timestamp time.Time
+	User      string
+	Comment   string
+	Bot       bool
+}
+
+// FakeWikiWrite records a successful edit or delete.
+type FakeWikiWrite struct {
+	Action  string // "edit" or "delete"
+	Title   string
+	Content string
+	Summary string
+	User    string
+	Bot     bool
+}
+
+// fakeUser is an account registered with AddUser.
+type fakeUser struct {
+	password string
+	rights   []string
+	groups   []string
+}
+
+// defaultRights are the rights AddUser grants: those of a bot with delete rights.
+var (
+	defaultRights = []string{"read", "edit", "bot", "delete"}
+	defaultGroups = []string{"*", "user", "bot", "sysop"}
+)
+
+type fakeSession struct {
+	user       string
+	loginToken string
+	csrfToken  string
+}
+
+// FakeWiki is an httptest-based fake of the MediaWiki action API. It supports
+// action=query (meta=tokens, meta=userinfo, meta=siteinfo, prop=revisions,
+// list=allpages with continuation), action=login, action=edit (with baserevid and
+// createonly) and action=delete, and honours assert=user and assert=bot.
+// Failures can be injected per action with FailNext.
+type FakeWiki struct {
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	pages    map[string]*FakeWikiPage
+	users    map[string]*fakeUser
+	sessions map[string]*fakeSession
+	failures map[string][]string
+	writes   []FakeWikiWrite
+	requests []map[string]string
+	nextRev  int64
+	pageSize int
+	// readOnly is the reason the wiki is read-only; "" when writable
+	readOnly string
+}
+
+// NewFakeWiki starts a FakeWiki. Call Close when done.
+func NewFakeWiki() *FakeWiki {
+	w := &FakeWiki{
+		pages:    make(map[string]*FakeWikiPage),
+		users:    make(map[string]*fakeUser),
+		sessions: make(map[string]*fakeSession),
+		failures: make(map[string][]string),
+		pageSize: 500,
+	}
+	w.Server = httptest.NewServer(http.HandlerFunc(w.serveHTTP))
+	return w
+}
+
+// URL returns the api.php endpoint of the fake.
+func (w *FakeWiki) URL() string {
+	return w.Server.URL + "/api.php"
+}
+
+// Close shuts the server down.
+func (w *FakeWiki) Close() {
+	w.Server.Close()
+}
+
+// AddUser registers an account that can log in with action=login. It has
+// the edit, bot and delete rights.
+func (w *FakeWiki) AddUser(name, password string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.users[name] = &fakeUser{password: password, rights: defaultRights, groups: defaultGroups}
+}
+
+// SetUserRights replaces the rights and groups reported for an account.
+func (w *FakeWiki) SetUserRights(name string, rights, groups []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if u, ok := w.users[name]; ok {
+		u.rights, u.groups = rights, groups
+	}
+}
+
+// SetPageSize limits the number of titles returned per list=allpages request
+// so continuation can be exercised.
+func (w *FakeWiki) SetPageSize(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pageSize = n
+}
+
+// SetReadOnly puts the wiki into read-only mode, as during maintenance:
+// edits and deletions fail with "readonly" and meta=siteinfo reports the
+// reason. An empty reason makes it writable again.
+func (w *FakeWiki) SetReadOnly(reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.readOnly = reason
+}
+
+// SetPage creates or replaces a page without recording a write.
+func (w *FakeWiki) SetPage(title, content string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.putLocked(title, content, "", "", false)
+}
+
+// Page returns the content of a page.
+func (w *FakeWiki) Page(title string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.pages[normalize(title)]
+	if !ok {
+		return "", false
+	}
+	return p.Content, true
+}
+
+// Titles returns all page titles, sorted.
+func (w *FakeWiki) Titles() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	titles := make([]string, 0, len(w.pages))
+	for t := range w.pages {
+		titles = append(titles, t)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// Writes returns the successful edits and deletes in order.
+func (w *FakeWiki) Writes() []FakeWikiWrite {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]FakeWikiWrite(nil), w.writes...)
+}
+
+// Requests returns the form parameters of every request received.
+func (w *FakeWiki) Requests() []map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]map[string]string(nil), w.requests...)
+}
+
+// FailNext makes the next request with the given action ("edit", "delete",
+// "login", "query") fail with the API error code, e.g. "badtoken",
+// "editconflict", "ratelimited" or "readonly". A code like "http-502"
+// answers with an HTML error page and that status instead, as a CDN in front
+// of the wiki would. Calls queue up.
+func (w *FakeWiki) FailNext(action, code string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures[action] = append(w.failures[action], code)
+}
+
+// DropSessions forgets all sessions, as if the wiki's session store was
+// flushed. Subsequent writes with old tokens fail with badtoken.
+func (w *FakeWiki) DropSessions() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sessions = make(map[string]*fakeSession)
+}
+
+func normalize(title string) string {
+	return strings.ReplaceAll(strings.TrimSpace(title), "_", " ")
+}
+
+func randomToken() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (w *FakeWiki) putLocked(title, content, user, comment string, bot bool) *FakeWikiPage {
+	w.nextRev++
+	p := &FakeWikiPage{
+		Title:     normalize(title),
+		Content:   content,
+		RevID:     w.nextRev,
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+		User:      user,
+		Comment:   comment,
+		Bot:       bot,
+	}
+	w.pages[p.Title] = p
+	return p
+}
+
+// sessionLocked returns the session of the request, creating one if needed.
+func (w *FakeWiki) sessionLocked(rw http.ResponseWriter, r *http.Request) *fakeSession {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if s, ok := w.sessions[c.Value]; ok {
+			return s
+		}
+	}
+	id := randomToken()
+	s := &fakeSession{}
+	w.sessions[id] = s
+	http.SetCookie(rw, &http.Cookie{Name: sessionCookie, Value: id, Path: "/"})
+	return s
+}
+
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+func apiError(code, info string) map[string]any {
+	return map[string]any{"error": map[string]any{"code": code, "info": info}}
+}
+
+func (w *FakeWiki) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := make(map[string]string, len(r.Form))
+	for k := range r.Form {
+		params[k] = r.Form.Get(k)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.requests = append(w.requests, params)
+	sess := w.sessionLocked(rw, r)
+
+	action := params["action"]
+	if q := w.failures[action]; len(q) > 0 {
+		w.failures[action] = q[1:]
+		if status, err := strconv.Atoi(strings.TrimPrefix(q[0], "http-")); err == nil {
+			rw.Header().Set("Content-Type", "text/html")
+			rw.WriteHeader(status)
+			fmt.Fprintf(rw, "<html><body><h1>%d %s</h1></body></html>", status, http.StatusText(status))
+			return
+		}
+		writeJSON(rw, apiError(q[0], "injected failure"))
+		return
+	}
+
+	if !w.checkAssertLocked(rw, sess, params["assert"]) {
+		return
+	}
+
+	if w.readOnly != "" && (action == "edit" || action == "delete") {
+		writeJSON(rw, apiError("readonly", "The wiki is currently in read-only mode."))
+		return
+	}
+
+	switch action {
+	case "query":
+		w.handleQuery(rw, sess, params)
+	case "login":
+		w.handleLogin(rw, sess, params)
+	case "edit":
+		w.handleEdit(rw, sess, params)
+	case "delete":
+		w.handleDelete(rw, sess, params)
+	default:
+		writeJSON(rw, apiError("badvalue", "Unrecognized value for parameter \"action\""))
+	}
+}
+
+func (w *FakeWiki) handleQuery(rw http.ResponseWriter, sess *fakeSession, params map[string]string) {
+	query := map[string]any{}
+	resp := map[string]any{"batchcomplete": "", "query": query}
+
+	if params["meta"] == "tokens" {
+		tokens := map[string]any{}
+		for _, t := range strings.Split(params["type"], "|") {
+			switch t {
+			case "login":
+				sess.loginToken = randomToken() + "+\\"
+				tokens["logintoken"] = sess.loginToken
+			case "csrf":
+				if sess.csrfToken == "" {
+					sess.csrfToken = randomToken() + "+\\"
+				}
+				if sess.user == "" {
+					// anonymous users get the placeholder token
+					tokens["csrftoken"] = "+\\"
+				} else {
+					tokens["csrftoken"] = sess.csrfToken
+				}
+			}
+		}
+		query["tokens"] = tokens
+	}
+
+	if params["meta"] == "userinfo" {
+		if u, ok := w.users[sess.user]; ok && sess.user != "" {
+			query["userinfo"] = map[string]any{"id": len(sess.user), "name": sess.user, "rights": u.rights, "groups": u.groups}
+		} else {
+			query["userinfo"] = map[string]any{"id": 0, "name": "127.0.0.1", "anon": "", "rights": []string{"read"}, "groups": []string{"*"}}
+		}
+	}
+
+	if params["meta"] == "siteinfo" {
+		general := map[string]any{"sitename": "Fake Wiki", "generator": "MediaWiki 1.43.0"}
+		if w.readOnly != "" {
+			general["readonly"] = ""
+			general["readonlyreason"] = w.readOnly
+		}
+		query["general"] = general
+		if strings.Contains(params["siprop"], "namespaces") {
+			namespaces := map[string]any{"0": map[string]any{"id": 0, "*": "", "case": "first-letter"}}
+			for name, id := range namespaceIDs {
+				namespaces[strconv.Itoa(id)] = map[string]any{"id": id, "*": name, "canonical": name, "case": "first-letter"}
+			}
+			query["namespaces"] = namespaces
+			query["namespacealiases"] = []any{}
+		}
+	}
+
+	if params["list"] == "allpages" {
+		ns, _ := strconv.Atoi(params["apnamespace"])
+		var titles []string
+		for t := range w.pages {
+			pageNS, rest := 0, t
+			for name, id := range namespaceIDs {
+				if after, ok := strings.CutPrefix(t, name+":"); ok {
+					pageNS, rest = id, after
+				}
+			}
+			if pageNS == ns && strings.HasPrefix(rest, normalize(params["apprefix"])) {
+				titles = append(titles, t)
+			}
+		}
+		sort.Strings(titles)
+		start := 0
+		if from := params["apcontinue"]; from != "" {
+			start = sort.SearchStrings(titles, from)
+		}
+		end := min(start+w.pageSize, len(titles))
+		list := make([]any, 0, end-start)
+		for _, t := range titles[start:end] {
+			list = append(list, map[string]any{"ns": ns, "title": t})
+		}
+		query["allpages"] = list
+		if end < len(titles) {
+			resp["continue"] = map[string]any{"apcontinue": titles[end], "continue": "-||"}
+			delete(resp, "batchcomplete")
+		}
+	}
+
+	if params["prop"] == "revisions" && params["titles"] != "" {
+		pages := map[string]any{}
+		var normalized []any
+		missingID := -1
+		for _, t := range strings.Split(params["titles"], "|") {
+			n := normalize(t)
+			if n != t {
+				normalized = append(normalized, map[string]any{"from": t, "to": n})
+			}
+			p, ok := w.pages[n]
+			if !ok {
+				pages[strconv.Itoa(missingID)] = map[string]any{"title": n, "missing": ""}
+				missingID--
+				continue
+			}
+			rev := map[string]any{
+				"revid":     p.RevID,
+				"timestamp": p.Timestamp.Format(time.RFC3339),
+				"user":      p.User,
+				"comment":   p.Comment,
+				"slots":     map[string]any{"main": map[string]any{"contentmodel": "wikitext", "*": p.Content}},
+			}
+			if p.Bot {
+				rev["bot"] = ""
+			}
+			pages[strconv.FormatInt(p.RevID, 10)] = map[string]any{"title": p.Title, "revisions": []any{rev}}
+		}
+		if len(normalized) > 0 {
+			query["normalized"] = normalized
+		}
+		query["pages"] = pages
+	}
+
+	writeJSON(rw, resp)
+}
+
+func (w *FakeWiki) handleLogin(rw http.ResponseWriter, sess *fakeSession, params map[string]string) {
+	if sess.loginToken == "" || params["lgtoken"] != sess.loginToken {
+		writeJSON(rw, map[string]any{"login": map[string]any{"result": "Failed", "reason": "Unable to continue login. Your session most likely timed out."}})
+		return
+	}
+	name := params["lgname"]
+	if u, ok := w.users[name]; !ok || u.password != params["lgpassword"] {
+		writeJSON(rw, map[string]any{"login": map[string]any{"result": "Failed", "reason": "Incorrect username or password entered. Please try again."}})
+		return
+	}
+	sess.user = name
+	sess.csrfToken = randomToken() + "+\\"
+	writeJSON(rw, map[string]any{"login": map[string]any{"result": "Success", "lgusername": name}})
+}
+
+// checkAssertLocked implements the assert parameter.
+func (w *FakeWiki) checkAssertLocked(rw http.ResponseWriter, sess *fakeSession, assert string) bool {
+	switch assert {
+	case "user":
+		if sess.user == "" {
+			writeJSON(rw, apiError("assertuserfailed", "You are no longer logged in, so the action could not be completed."))
+			return false
+		}
+	case "bot":
+		u, ok := w.users[sess.user]
+		if sess.user == "" || !ok || !slices.Contains(u.rights, "bot") {
+			writeJSON(rw, apiError("assertbotfailed", "You do not have the \"bot\" right, so the action could not be completed."))
+			return false
+		}
+	}
+	return true
+}
+
+// checkWriteToken validates the CSRF token of a write request.
+func (w *FakeWiki) checkWriteToken(rw http.ResponseWriter, sess *fakeSession, params map[string]string) bool {
+	token := params["token"]
+	valid := token != "" && (token == sess.csrfToken || (sess.user == "" && token == "+\\"))
+	if !valid {
+		writeJSON(rw, apiError("badtoken", "Invalid CSRF token."))
+	}
+	return valid
+}
+
+func (w *FakeWiki) handleEdit(rw http.ResponseWriter, sess *fakeSession, params map[string]string) {
+	if !w.checkWriteToken(rw, sess, params) {
+		return
+	}
+	title := normalize(params["title"])
+	if title == "" {
+		writeJSON(rw, apiError("missingparam", "The title parameter must be set."))
+		return
+	}
+	bot := params["bot"] != "" && sess.user != ""
+	cur, exists := w.pages[title]
+	if params["createonly"] != "" && exists {
+		writeJSON(rw, apiError("articleexists", "The article you tried to create has been created already."))
+		return
+	}
+	if base := params["baserevid"]; base != "" && (!exists || strconv.FormatInt(cur.RevID, 10) != base) {
+		writeJSON(rw, apiError("editconflict", "Edit conflict."))
+		return
+	}
+	if p, ok := w.pages[title]; ok && p.Content == params["text"] {
+		writeJSON(rw, map[string]any{"edit": map[string]any{"result": "Success", "title": title, "nochange": ""}})
+		return
+	}
+	p := w.putLocked(title, params["text"], sess.user, params["summary"], bot)
+	w.writes = append(w.writes, FakeWikiWrite{Action: "edit", Title: title, Content: p.Content, Summary: p.Comment, User: sess.user, Bot: bot})
+	writeJSON(rw, map[string]any{"edit": map[string]any{"result": "Success", "title": title, "newrevid": p.RevID}})
+}
+
+func (w *FakeWiki) handleDelete(rw http.ResponseWriter, sess *fakeSession, params map[string]string) {
+	if !w.checkWriteToken(rw, sess, params) {
+		return
+	}
+	title := normalize(params["title"])
+	if _, ok := w.pages[title]; !ok {
+		writeJSON(rw, apiError("missingtitle", "The page you specified doesn't exist."))
+		return
+	}
+	delete(w.pages, title)
+	w.writes = append(w.writes, FakeWikiWrite{Action: "delete", Title: title, Summary: params["reason"], User: sess.user})
+	writeJSON(rw, map[string]any{"delete": map[string]any{"title": title, "reason": params["reason"]}})
+}
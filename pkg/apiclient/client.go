@@ -5,78 +5,120 @@ package apiclient
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+	"time"
 
-	"github.com/r3labs/sse/v2"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// SSEHandlers contains callbacks for supported server-sent events.
+var tracer = otel.Tracer("github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient")
+
+// SSEHandlers contains callbacks for supported server-sent events. Each
+// callback receives a context carrying the receipt span of the event, so
+// downstream work can be linked to it.
 type SSEHandlers struct {
-	OnPackageAdded   func(event PackageAddedEvent)
-	OnPackageUpdated func(event PackageUpdatedEvent)
-	OnPackageRemoved func(event PackageRemovedEvent)
+	OnPackageAdded   func(ctx context.Context, event PackageAddedEvent)
+	OnPackageUpdated func(ctx context.Context, event PackageUpdatedEvent)
+	OnPackageRemoved func(ctx context.Context, event PackageRemovedEvent)
+
+	OnPackageYanked     func(ctx context.Context, event PackageYankedEvent)
+	OnPackageDeprecated func(ctx context.Context, event PackageDeprecatedEvent)
+	OnRepositoryUpdated func(ctx context.Context, event RepositoryUpdatedEvent)
+	// OnResync is called when the server asks for a full resynchronisation.
+	OnResync func(ctx context.Context, event ResyncEvent)
 
 	// Optional generic hook for unhandled events.
-	OnUnknown func(name string, raw json.RawMessage)
+	OnUnknown func(ctx context.Context, name string, raw json.RawMessage)
+	// Optional hook for known events whose payload could not be decoded.
+	OnDecodeError func(ctx context.Context, name string, raw json.RawMessage, err error)
+
+	// Optional connection hooks. OnConnect runs once the stream answered with
+	// 200. OnDisconnect runs each time a connection attempt of an SSEStream
+	// ended, with the delay before the next; ListenSSE returning means the
+	// stream is down.
+	OnConnect    func()
+	OnDisconnect func(err error, retryIn time.Duration)
+
+	// Optional logger for stream diagnostics such as undecodable events.
+	Logger *slog.Logger
 }
 
-// ListenSSE connects to the SSE endpoint and dispatches events to provided handlers.
+// ListenSSE connects to the SSE endpoint once and dispatches events to the
+// provided handlers. It does not reconnect: it returns when the stream ends or
+// fails, leaving backoff and fallback decisions to the caller. Use SSEStream
+// for idle detection and automatic reconnects.
 func ListenSSE(ctx context.Context, sseURL string, httpClient *http.Client, lastID *string, h SSEHandlers) error {
-	client := sse.NewClient(sseURL)
-	if httpClient != nil {
-		// r3labs/sse v2 uses Connection for custom transports/timeouts
-		client.Connection = httpClient
+	s := NewSSEStream(sseURL, httpClient, h, SSEOptions{})
+	if lastID != nil {
+		s.state.LastEventID = *lastID
 	}
-	// Ensure we request the proper stream content type
-	if client.Headers == nil {
-		client.Headers = make(map[string]string)
+	err := s.connect(ctx)
+	if lastID != nil {
+		*lastID = s.State().LastEventID
 	}
-	client.Headers["Accept"] = "text/event-stream"
-	if lastID != nil && *lastID != "" {
-		client.Headers["Last-Event-ID"] = *lastID
+	return err
+}
+
+// payloadID returns the "id" field of a JSON event payload, for servers that
+// put the event ID into the data instead of an id: line.
+func payloadID(data []byte) string {
+	var idWrap struct {
+		ID string `json:"id"`
 	}
+	_ = json.Unmarshal(data, &idWrap)
+	return idWrap.ID
+}
 
-	// Use context-aware subscription; empty channel subscribes to default stream
-	return client.SubscribeWithContext(ctx, "", func(msg *sse.Event) {
-		// update lastID if available on each domain event
-		if lastID != nil {
-			// prefer SSE ID if present
-			if len(msg.ID) > 0 {
-				*lastID = string(msg.ID)
-			} else {
-				var idWrap struct {
-					ID string `json:"id"`
-				}
-				_ = json.Unmarshal(msg.Data, &idWrap)
-				if idWrap.ID != "" {
-					*lastID = idWrap.ID
-				}
-			}
+// dispatch decodes msg and calls the matching handler.
+func (h SSEHandlers) dispatch(ctx context.Context, msg sseMessage) {
+	logger := logging.OrDiscard(h.Logger)
+	name := msg.event
+	data := msg.data
+	if len(data) == 0 {
+		if name != EventResync {
+			return
 		}
-		name := string(msg.Event)
-		if len(msg.Data) == 0 {
+		// resync hints carry no required payload
+		data = []byte("{}")
+	}
+	ctx, span := tracer.Start(ctx, "sse.event "+name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("sse.event", name),
+		attribute.String("sse.id", msg.id),
+	))
+	defer span.End()
+	onErr := func(err error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "decode event")
+		if h.OnDecodeError != nil {
+			h.OnDecodeError(ctx, name, json.RawMessage(data), err)
 			return
 		}
-		switch name {
-		case "package.added":
-			var ev PackageAddedEvent
-			if err := json.Unmarshal(msg.Data, &ev); err == nil && h.OnPackageAdded != nil {
-				h.OnPackageAdded(ev)
-			}
-		case "package.updated":
-			var ev PackageUpdatedEvent
-			if err := json.Unmarshal(msg.Data, &ev); err == nil && h.OnPackageUpdated != nil {
-				h.OnPackageUpdated(ev)
-			}
-		case "package.removed":
-			var ev PackageRemovedEvent
-			if err := json.Unmarshal(msg.Data, &ev); err == nil && h.OnPackageRemoved != nil {
-				h.OnPackageRemoved(ev)
-			}
-		default:
-			if h.OnUnknown != nil {
-				h.OnUnknown(name, json.RawMessage(msg.Data))
-			}
+		logger.WarnContext(ctx, "sse: drop undecodable event", "event", name, "id", msg.id, "error", err)
+	}
+	switch name {
+	case EventPackageAdded:
+		decodeEvent(ctx, data, h.OnPackageAdded, onErr)
+	case EventPackageUpdated:
+		decodeEvent(ctx, data, h.OnPackageUpdated, onErr)
+	case EventPackageRemoved:
+		decodeEvent(ctx, data, h.OnPackageRemoved, onErr)
+	case EventPackageYanked:
+		decodeEvent(ctx, data, h.OnPackageYanked, onErr)
+	case EventPackageDeprecated:
+		decodeEvent(ctx, data, h.OnPackageDeprecated, onErr)
+	case EventRepositoryUpdated:
+		decodeEvent(ctx, data, h.OnRepositoryUpdated, onErr)
+	case EventResync:
+		decodeEvent(ctx, data, h.OnResync, onErr)
+	default:
+		logger.DebugContext(ctx, "sse: unhandled event", "event", name, "id", msg.id)
+		if h.OnUnknown != nil {
+			h.OnUnknown(ctx, name, json.RawMessage(data))
 		}
-	})
+	}
 }
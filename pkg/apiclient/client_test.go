@@ -0,0 +1,92 @@
+package apiclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/testsupport"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+)
+
+func added(name, version string) apiclient.PackageAddedEvent {
+	return apiclient.PackageAddedEvent{Identifier: apiclient.PackageIdentifier{Name: name, Version: version}}
+}
+
+func publish(t *testing.T, vpmm *testsupport.FakeVPMM, event string, payload any) string {
+	t.Helper()
+	id, err := vpmm.Publish(event, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestListenSSE(t *testing.T) {
+	vpmm := testsupport.NewFakeVPMM()
+	defer vpmm.Close()
+	seen := publish(t, vpmm, apiclient.EventPackageAdded, added("com.example.old", "1.0.0"))
+	publish(t, vpmm, apiclient.EventPackageAdded, added("com.example.missed", "1.0.0"))
+
+	got := make(chan string, 8)
+	handlers := apiclient.SSEHandlers{
+		OnPackageAdded: func(_ context.Context, ev apiclient.PackageAddedEvent) {
+			got <- "added " + ev.Identifier.Name + "@" + ev.Identifier.Version
+		},
+		OnPackageRemoved: func(_ context.Context, ev apiclient.PackageRemovedEvent) {
+			got <- "removed " + ev.Identifier.Name + "@" + ev.Identifier.Version
+		},
+		OnResync: func(_ context.Context, ev apiclient.ResyncEvent) {
+			got <- "resync " + ev.Reason
+		},
+		OnUnknown: func(_ context.Context, name string, _ json.RawMessage) {
+			got <- "unknown " + name
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lastID := seen
+	done := make(chan error, 1)
+	go func() { done <- apiclient.ListenSSE(ctx, vpmm.URL()+"/sse", nil, &lastID, handlers) }()
+
+	// the event published after Last-Event-ID is replayed first
+	want := []string{"added com.example.missed@1.0.0"}
+	waitEvents(t, got, want)
+
+	for deadline := time.Now().Add(5 * time.Second); vpmm.Subscribers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("ListenSSE did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	publish(t, vpmm, apiclient.EventPackageRemoved, apiclient.PackageRemovedEvent{Identifier: apiclient.PackageIdentifier{Name: "com.example.old", Version: "1.0.0"}})
+	publish(t, vpmm, "package.renamed", map[string]string{"from": "a", "to": "b"})
+	last := publish(t, vpmm, apiclient.EventResync, apiclient.ResyncEvent{Reason: "rebuilt"})
+	waitEvents(t, got, []string{"removed com.example.old@1.0.0", "unknown package.renamed", "resync rebuilt"})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenSSE did not return after cancellation")
+	}
+	if lastID != last {
+		t.Errorf("lastID = %q, want %q", lastID, last)
+	}
+}
+
+func waitEvents(t *testing.T, got <-chan string, want []string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case g := <-got:
+			if g != w {
+				t.Fatalf("got event %q, want %q", g, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// SSE event names published by VPMM.
+const (
+	EventPackageAdded      = "package.added"
+	EventPackageUpdated    = "package.updated"
+	EventPackageRemoved    = "package.removed"
+	EventPackageYanked     = "package.yanked"
+	EventPackageDeprecated = "package.deprecated"
+	EventRepositoryUpdated = "repository.updated"
+	EventResync            = "resync"
+)
+
+// The following events are not described by the OpenAPI spec yet, so their
+// payloads are modelled here.
+
+// PackageYankedEvent reports a version withdrawn from the listing.
+type PackageYankedEvent struct {
+	Identifier PackageIdentifier `json:"identifier"`
+	Reason     string            `json:"reason,omitempty"`
+}
+
+// PackageDeprecatedEvent reports a package or version marked as deprecated.
+type PackageDeprecatedEvent struct {
+	Identifier PackageIdentifier `json:"identifier"`
+	Message    string            `json:"message,omitempty"`
+}
+
+// RepositoryUpdatedEvent reports a change of repository-level metadata
+// (name, id, url or author) of the listing.
+type RepositoryUpdatedEvent struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// ResyncEvent asks consumers to run a full resynchronisation, e.g. after the
+// server rebuilt its index or lost event history.
+type ResyncEvent struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// decodeEvent unmarshals raw into a T and passes it to fn. Decode failures go
+// to onErr; a nil fn only validates the payload.
+func decodeEvent[T any](ctx context.Context, raw []byte, fn func(context.Context, T), onErr func(error)) {
+	var ev T
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		onErr(err)
+		return
+	}
+	if fn != nil {
+		fn(ctx, ev)
+	}
+}
@@ -0,0 +1,293 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RepositoryListing is the VCC/VPM repository listing served at /index.json.
+// The OpenAPI spec does not describe this payload, so it is modelled here.
+type RepositoryListing struct {
+	Name     string                    `json:"name,omitempty"`
+	ID       string                    `json:"id,omitempty"`
+	URL      string                    `json:"url,omitempty"`
+	Author   string                    `json:"author,omitempty"`
+	Packages map[string]ListingPackage `json:"packages"`
+}
+
+// ListingPackage holds all published versions of one package, keyed by version.
+type ListingPackage struct {
+	Versions map[string]Package `json:"versions"`
+}
+
+// IndexError is returned by FetchIndex for non-200 responses. Problem is set
+// when the server sent an application/problem+json body.
+type IndexError struct {
+	StatusCode int
+	Status     string
+	Problem    *ErrorModel
+}
+
+func (e *IndexError) Error() string {
+	kind := "unexpected status"
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		kind = "unauthorized"
+	case http.StatusUnprocessableEntity:
+		kind = "unprocessable"
+	case http.StatusInternalServerError:
+		kind = "server error"
+	}
+	if detail := problemDetail(e.Problem); detail != "" {
+		return fmt.Sprintf("get index: %s: %s", kind, detail)
+	}
+	return fmt.Sprintf("get index: %s: %s", kind, e.Status)
+}
+
+var (
+	// ErrEmptyIndex is returned by FetchIndex when the server sent an empty body.
+	ErrEmptyIndex = errors.New("get index: empty response body")
+	// ErrIndexNotModified is returned by FetchIndexIfChanged on 304 Not Modified.
+	ErrIndexNotModified = errors.New("get index: not modified")
+)
+
+// IndexValidators are the HTTP cache validators of a fetched index.
+type IndexValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// FetchIndex downloads and decodes /index.json.
+func (c *ClientWithResponses) FetchIndex(ctx context.Context, reqEditors ...RequestEditorFn) (*RepositoryListing, error) {
+	listing, _, err := c.FetchIndexIfChanged(ctx, IndexValidators{}, reqEditors...)
+	return listing, err
+}
+
+// FetchIndexIfChanged downloads /index.json unless it still matches prev
+// (If-None-Match / If-Modified-Since), in which case it returns
+// ErrIndexNotModified. It returns the validators of the new index.
+func (c *ClientWithResponses) FetchIndexIfChanged(ctx context.Context, prev IndexValidators, reqEditors ...RequestEditorFn) (*RepositoryListing, IndexValidators, error) {
+	ctx, span := tracer.Start(ctx, "vpmm.get_index", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	if prev.ETag != "" || prev.LastModified != "" {
+		reqEditors = append(reqEditors, func(_ context.Context, req *http.Request) error {
+			if prev.ETag != "" {
+				req.Header.Set("If-None-Match", prev.ETag)
+			}
+			if prev.LastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.LastModified)
+			}
+			return nil
+		})
+	}
+	listing, validators, err := c.fetchIndex(ctx, span, reqEditors...)
+	if errors.Is(err, ErrIndexNotModified) {
+		span.SetAttributes(attribute.Bool("vpm.index_not_modified", true))
+		return nil, prev, err
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, IndexValidators{}, err
+	}
+	span.SetAttributes(attribute.Int("vpm.packages", len(listing.Packages)))
+	return listing, validators, nil
+}
+
+func (c *ClientWithResponses) fetchIndex(ctx context.Context, span trace.Span, reqEditors ...RequestEditorFn) (*RepositoryListing, IndexValidators, error) {
+	resp, err := c.GetIndexWithResponse(ctx, nil, reqEditors...)
+	if err != nil {
+		return nil, IndexValidators{}, fmt.Errorf("get index: %w", err)
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode()))
+	if resp.StatusCode() == http.StatusNotModified {
+		return nil, IndexValidators{}, ErrIndexNotModified
+	}
+	if resp.StatusCode() != http.StatusOK {
+		// prefer structured error payloads when available
+		problem := resp.ApplicationproblemJSON401
+		if problem == nil {
+			problem = resp.ApplicationproblemJSON422
+		}
+		if problem == nil {
+			problem = resp.ApplicationproblemJSON500
+		}
+		return nil, IndexValidators{}, &IndexError{StatusCode: resp.StatusCode(), Status: resp.Status(), Problem: problem}
+	}
+	var header http.Header
+	if resp.HTTPResponse != nil {
+		header = resp.HTTPResponse.Header
+	}
+	return decodeListing(resp.Body, header)
+}
+
+// decodeListing decodes a 200 listing response and its cache validators.
+func decodeListing(body []byte, header http.Header) (*RepositoryListing, IndexValidators, error) {
+	if len(body) == 0 {
+		return nil, IndexValidators{}, ErrEmptyIndex
+	}
+	var listing RepositoryListing
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, IndexValidators{}, fmt.Errorf("get index: decode json: %w", err)
+	}
+	return &listing, IndexValidators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}, nil
+}
+
+// FetchListing downloads a VCC repository listing from an arbitrary URL (for
+// listings not served by VPMM). Like FetchIndexIfChanged it sends prev as
+// conditional headers and returns ErrIndexNotModified on 304.
+func FetchListing(ctx context.Context, httpClient HttpRequestDoer, listingURL string, prev IndexValidators) (*RepositoryListing, IndexValidators, error) {
+	ctx, span := tracer.Start(ctx, "vpmm.get_listing", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", listingURL)))
+	defer span.End()
+
+	listing, validators, err := fetchListing(ctx, span, httpClient, listingURL, prev)
+	if errors.Is(err, ErrIndexNotModified) {
+		span.SetAttributes(attribute.Bool("vpm.index_not_modified", true))
+		return nil, prev, err
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, IndexValidators{}, err
+	}
+	span.SetAttributes(attribute.Int("vpm.packages", len(listing.Packages)))
+	return listing, validators, nil
+}
+
+func fetchListing(ctx context.Context, span trace.Span, httpClient HttpRequestDoer, listingURL string, prev IndexValidators) (*RepositoryListing, IndexValidators, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listingURL, nil)
+	if err != nil {
+		return nil, IndexValidators{}, fmt.Errorf("get listing: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, IndexValidators{}, fmt.Errorf("get listing: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, IndexValidators{}, ErrIndexNotModified
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, IndexValidators{}, fmt.Errorf("get listing: read body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, IndexValidators{}, &IndexError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return decodeListing(body, resp.Header)
+}
+
+// PackageDiff lists what changed for one package between two listings.
+type PackageDiff struct {
+	AddedVersions   []string
+	RemovedVersions []string
+	// ChangedVersions are versions present in both listings whose metadata differs.
+	ChangedVersions []string
+	// RepositoryChanged is set when the package is in both listings but the
+	// name, id or url of the listing itself differs.
+	RepositoryChanged bool
+}
+
+// ListingDiff maps package names to their changes. Unchanged packages are absent.
+type ListingDiff map[string]PackageDiff
+
+// Packages returns the names of all changed packages, sorted.
+func (d ListingDiff) Packages() []string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DiffListings compares two listings package by package. A nil prev treats
+// every package of next as added. When the name, id or url of the listing
+// changed, every package in both listings is reported with RepositoryChanged.
+func DiffListings(prev, next *RepositoryListing) ListingDiff {
+	diff := ListingDiff{}
+	var prevPkgs, nextPkgs map[string]ListingPackage
+	if prev != nil {
+		prevPkgs = prev.Packages
+	}
+	if next != nil {
+		nextPkgs = next.Packages
+	}
+	repoChanged := prev != nil && next != nil &&
+		(prev.Name != next.Name || prev.ID != next.ID || prev.URL != next.URL)
+	for name, np := range nextPkgs {
+		pp, existed := prevPkgs[name]
+		d := PackageDiff{RepositoryChanged: repoChanged && existed}
+		for v, pkg := range np.Versions {
+			old, ok := pp.Versions[v]
+			switch {
+			case !ok:
+				d.AddedVersions = append(d.AddedVersions, v)
+			case !reflect.DeepEqual(old, pkg):
+				d.ChangedVersions = append(d.ChangedVersions, v)
+			}
+		}
+		for v := range pp.Versions {
+			if _, ok := np.Versions[v]; !ok {
+				d.RemovedVersions = append(d.RemovedVersions, v)
+			}
+		}
+		if len(d.AddedVersions)+len(d.RemovedVersions)+len(d.ChangedVersions) > 0 || d.RepositoryChanged {
+			sort.Strings(d.AddedVersions)
+			sort.Strings(d.RemovedVersions)
+			sort.Strings(d.ChangedVersions)
+			diff[name] = d
+		}
+	}
+	for name, pp := range prevPkgs {
+		if _, ok := nextPkgs[name]; ok {
+			continue
+		}
+		d := PackageDiff{}
+		for v := range pp.Versions {
+			d.RemovedVersions = append(d.RemovedVersions, v)
+		}
+		sort.Strings(d.RemovedVersions)
+		diff[name] = d
+	}
+	return diff
+}
+
+func problemDetail(e *ErrorModel) string {
+	if e == nil {
+		return ""
+	}
+	if e.Title != nil && e.Detail != nil {
+		return *e.Title + ": " + *e.Detail
+	}
+	if e.Detail != nil {
+		return *e.Detail
+	}
+	if e.Title != nil {
+		return *e.Title
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package apiclient
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/retry"
+)
+
+// RetryingDoer is an HttpRequestDoer that retries transient failures
+// (network errors, 408, 429, 5xx) of the wrapped doer according to a retry
+// policy. GET, HEAD and OPTIONS requests are retried whenever the failure is
+// transient, other methods only when the request never reached the server.
+// Pass it to NewClientWithResponses with WithHTTPClient, or to FetchListing.
+type RetryingDoer struct {
+	doer   HttpRequestDoer
+	policy retry.Policy
+	logger *slog.Logger
+}
+
+var _ HttpRequestDoer = (*RetryingDoer)(nil)
+
+// NewRetryingDoer wraps doer (http.DefaultClient if nil). logger may be nil.
+func NewRetryingDoer(doer HttpRequestDoer, policy retry.Policy, logger *slog.Logger) *RetryingDoer {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &RetryingDoer{doer: doer, policy: policy, logger: logging.OrDiscard(logger)}
+}
+
+// Do implements HttpRequestDoer. When retries run out on a retryable
+// status, the last response is returned as is, so callers still see it.
+func (d *RetryingDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions
+	var resp *http.Response
+	var doErr error
+	attempt := 0
+	_ = d.policy.Do(ctx, idempotent, func() error {
+		attempt++
+		r := req
+		if attempt > 1 {
+			next, err := rewind(req)
+			if err != nil {
+				// keep the previous response as the result
+				return err
+			}
+			if resp != nil {
+				_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+				resp.Body.Close()
+			}
+			r = next
+		}
+		resp, doErr = d.doer.Do(r)
+		if doErr != nil {
+			return retry.RequestError(doErr)
+		}
+		if resp.StatusCode < http.StatusBadRequest {
+			return nil
+		}
+		return retry.StatusError(resp, fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status))
+	}, func(err error, attempt int, delay time.Duration) {
+		d.logger.WarnContext(ctx, "vpmm request failed, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+	})
+	if doErr != nil {
+		return nil, doErr
+	}
+	return resp, nil
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return r, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("%s %s: request body cannot be replayed", req.Method, req.URL.Redacted())
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: replay request body: %w", req.Method, req.URL.Redacted(), err)
+	}
+	r.Body = body
+	return r, nil
+}
@@ -0,0 +1,330 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSSEIdle is returned when a stream delivered neither events nor
+// heartbeat comments for longer than the idle timeout, e.g. because the TCP
+// connection went half-open.
+var ErrSSEIdle = errors.New("sse: stream idle timeout")
+
+// Default reconnect backoff bounds of SSEStream.
+const (
+	DefaultSSEMinBackoff = time.Second
+	DefaultSSEMaxBackoff = 30 * time.Second
+)
+
+// ConnState is the connection state of an SSEStream.
+type ConnState int
+
+const (
+	// StateConnecting is the first connection attempt.
+	StateConnecting ConnState = iota
+	// StateConnected means the server answered 200 and the stream is open.
+	StateConnected
+	// StateReconnecting means the stream was lost and is waiting to reconnect.
+	StateReconnecting
+	// StateStopped means Run returned.
+	StateStopped
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// SSEOptions tune an SSEStream.
+type SSEOptions struct {
+	// IdleTimeout declares the stream dead when nothing, not even a comment
+	// heartbeat, arrived for this long. Zero disables idle detection.
+	IdleTimeout time.Duration
+	// MinBackoff and MaxBackoff bound the jittered reconnect delay. A
+	// server-sent retry: field raises the lower bound.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// SSEState is a snapshot of an SSEStream's connection.
+type SSEState struct {
+	State ConnState
+	// Since is when State was entered.
+	Since time.Time
+	// LastActivity is when the last event or heartbeat arrived.
+	LastActivity time.Time
+	// LastEventID is sent as Last-Event-ID when reconnecting.
+	LastEventID string
+	// Failures counts connection attempts failed since the last success.
+	Failures int
+	// LastError is why the stream was last lost.
+	LastError error
+}
+
+// SSEStream follows an SSE endpoint, reconnecting with jittered exponential
+// backoff until its context is cancelled. Use State to observe it.
+type SSEStream struct {
+	url        string
+	httpClient *http.Client
+	handlers   SSEHandlers
+	opts       SSEOptions
+
+	mu    sync.Mutex
+	state SSEState
+	// retry is the reconnect delay requested by the server
+	retry time.Duration
+}
+
+// NewSSEStream returns a stream for sseURL; call Run to start it.
+func NewSSEStream(sseURL string, httpClient *http.Client, h SSEHandlers, opts SSEOptions) *SSEStream {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultSSEMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultSSEMaxBackoff, opts.MinBackoff)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &SSEStream{
+		url:        sseURL,
+		httpClient: httpClient,
+		handlers:   h,
+		opts:       opts,
+		state:      SSEState{State: StateConnecting, Since: time.Now()},
+	}
+}
+
+// State returns the current connection state.
+func (s *SSEStream) State() SSEState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Run connects and dispatches events until ctx is done, reconnecting whenever
+// the stream fails, ends or goes idle. It returns ctx's error.
+func (s *SSEStream) Run(ctx context.Context) error {
+	defer s.setState(StateStopped, nil)
+	for {
+		err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		s.mu.Lock()
+		if s.state.State != StateConnected {
+			s.state.Failures++
+		}
+		delay := s.backoffLocked()
+		s.mu.Unlock()
+		s.setState(StateReconnecting, err)
+		if s.handlers.OnDisconnect != nil {
+			s.handlers.OnDisconnect(err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoffLocked returns the next reconnect delay: exponential in the number
+// of failures, between half and all of the bound, never below the server's
+// retry hint.
+func (s *SSEStream) backoffLocked() time.Duration {
+	bound := s.opts.MinBackoff
+	for i := 0; i < s.state.Failures && bound < s.opts.MaxBackoff; i++ {
+		bound *= 2
+	}
+	bound = min(bound, s.opts.MaxBackoff)
+	delay := bound/2 + rand.N(bound/2+1)
+	return max(delay, s.retry)
+}
+
+func (s *SSEStream) setState(state ConnState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state == StateConnected {
+		s.state.Failures = 0
+	}
+	if err != nil {
+		s.state.LastError = err
+	}
+	s.state.State = state
+	s.state.Since = time.Now()
+}
+
+func (s *SSEStream) touch() {
+	s.mu.Lock()
+	s.state.LastActivity = time.Now()
+	s.mu.Unlock()
+}
+
+// connect runs a single connection until it ends.
+func (s *SSEStream) connect(ctx context.Context) error {
+	connCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	req, err := http.NewRequestWithContext(connCtx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("sse: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if id := s.State().LastEventID; id != "" {
+		req.Header.Set("Last-Event-ID", id)
+	}
+
+	// the idle timer also covers the wait for response headers
+	var idle *time.Timer
+	if s.opts.IdleTimeout > 0 {
+		idle = time.AfterFunc(s.opts.IdleTimeout, func() { cancel(ErrSSEIdle) })
+		defer idle.Stop()
+	}
+	activity := func() {
+		if idle != nil {
+			idle.Reset(s.opts.IdleTimeout)
+		}
+		s.touch()
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return s.connError(connCtx, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sse: could not connect to stream: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "text/event-stream") {
+		return fmt.Errorf("sse: unexpected content type %q", ct)
+	}
+	activity()
+	s.setState(StateConnected, nil)
+	if s.handlers.OnConnect != nil {
+		s.handlers.OnConnect()
+	}
+
+	r := newSSEReader(resp.Body)
+	for {
+		msg, err := r.next(activity)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return s.connError(connCtx, err)
+		}
+		s.mu.Lock()
+		if r.retry > 0 {
+			s.retry = r.retry
+		}
+		if msg.id != "" {
+			s.state.LastEventID = msg.id
+		} else if id := payloadID(msg.data); id != "" {
+			s.state.LastEventID = id
+		}
+		s.mu.Unlock()
+		s.handlers.dispatch(ctx, msg)
+	}
+}
+
+// connError prefers the idle timeout over the cancellation it caused.
+func (s *SSEStream) connError(connCtx context.Context, err error) error {
+	if cause := context.Cause(connCtx); errors.Is(cause, ErrSSEIdle) {
+		return ErrSSEIdle
+	}
+	return fmt.Errorf("sse: %w", err)
+}
+
+// sseMessage is one dispatched server-sent event.
+type sseMessage struct {
+	id    string
+	event string
+	data  []byte
+}
+
+// sseReader parses a text/event-stream as described in the HTML living
+// standard (server-sent events, "event stream interpretation").
+type sseReader struct {
+	br *bufio.Reader
+	// lastID persists across events until the server changes it
+	lastID string
+	// retry is the latest reconnection time sent by the server
+	retry time.Duration
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{br: bufio.NewReader(r)}
+}
+
+// next returns the next event. activity is called for every line read,
+// including comments, which servers send as heartbeats.
+func (r *sseReader) next(activity func()) (sseMessage, error) {
+	var (
+		event   string
+		data    strings.Builder
+		hasData bool
+	)
+	for {
+		line, err := r.br.ReadString('\n')
+		if err != nil {
+			// an event without its terminating blank line is discarded
+			return sseMessage{}, err
+		}
+		activity()
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if !hasData && event == "" {
+				continue
+			}
+			return sseMessage{id: r.lastID, event: event, data: []byte(data.String())}, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
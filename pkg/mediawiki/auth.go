@@ -0,0 +1,171 @@
+package mediawiki
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication methods selectable via WikiConfig.Auth.
+const (
+	// AuthPassword logs in with action=login. Works for main-account
+	// passwords (deprecated by MediaWiki) and BotPasswords.
+	AuthPassword = "password"
+	// AuthBotPassword logs in with action=login using a BotPassword
+	// ("User@botname" plus the generated password).
+	AuthBotPassword = "botpassword"
+	// AuthOAuth2 signs every request with an OAuth 2.0 owner-only access token.
+	AuthOAuth2 = "oauth2"
+	// AuthOAuth1 signs every request with OAuth 1.0a owner-only consumer credentials.
+	AuthOAuth1 = "oauth1"
+)
+
+// OAuth1Credentials are the four secrets of an owner-only OAuth 1.0a consumer.
+type OAuth1Credentials struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	AccessToken    string
+	AccessSecret   string
+}
+
+// requestSigner adds credentials to an outgoing API request. form is the
+// request body, which OAuth 1.0a includes in the signature.
+type requestSigner interface {
+	sign(req *http.Request, form url.Values) error
+}
+
+// resolveAuthMethod validates the configured method and its credentials.
+func resolveAuthMethod(config WikiConfig) (string, requestSigner, error) {
+	method := strings.ToLower(strings.TrimSpace(config.Auth))
+	switch method {
+	case "":
+		// legacy behaviour: log in when a username/password is configured
+		return AuthPassword, nil, nil
+	case AuthPassword:
+		return method, nil, nil
+	case AuthBotPassword:
+		if !strings.Contains(config.Username, "@") {
+			return "", nil, fmt.Errorf("botpassword auth: username must have the form User@botname")
+		}
+		return method, nil, nil
+	case AuthOAuth2:
+		token := strings.TrimSpace(config.OAuth2Token)
+		if token == "" {
+			return "", nil, fmt.Errorf("oauth2 auth: access token is required")
+		}
+		return method, bearerSigner{token: token}, nil
+	case AuthOAuth1:
+		cr := config.OAuth1
+		if cr.ConsumerKey == "" || cr.ConsumerSecret == "" || cr.AccessToken == "" || cr.AccessSecret == "" {
+			return "", nil, fmt.Errorf("oauth1 auth: consumer key/secret and access token/secret are required")
+		}
+		return method, oauth1Signer{creds: cr}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown wiki auth method %q", config.Auth)
+	}
+}
+
+// bearerSigner implements OAuth 2.0 owner-only consumers.
+type bearerSigner struct {
+	token string
+}
+
+func (s bearerSigner) sign(req *http.Request, _ url.Values) error {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	return nil
+}
+
+// oauth1Signer implements OAuth 1.0a (RFC 5849) HMAC-SHA1 request signing.
+type oauth1Signer struct {
+	creds OAuth1Credentials
+}
+
+// oauthEscape percent-encodes per RFC 5849 section 3.6.
+func oauthEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('A' <= ch && ch <= 'Z') || ('a' <= ch && ch <= 'z') || ('0' <= ch && ch <= '9') ||
+			ch == '-' || ch == '.' || ch == '_' || ch == '~' {
+			b.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", ch)
+	}
+	return b.String()
+}
+
+func oauthNonce() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func (s oauth1Signer) sign(req *http.Request, form url.Values) error {
+	nonce, err := oauthNonce()
+	if err != nil {
+		return fmt.Errorf("oauth1 nonce: %w", err)
+	}
+	s.signWith(req, form, nonce, time.Now())
+	return nil
+}
+
+func (s oauth1Signer) signWith(req *http.Request, form url.Values, nonce string, now time.Time) {
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     s.creds.ConsumerKey,
+		"oauth_token":            s.creds.AccessToken,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(now.Unix(), 10),
+		"oauth_nonce":            nonce,
+		"oauth_version":          "1.0",
+	}
+
+	// collect oauth, query and body parameters, encoded and sorted
+	var pairs []string
+	add := func(k, v string) { pairs = append(pairs, oauthEscape(k)+"="+oauthEscape(v)) }
+	for k, v := range oauthParams {
+		add(k, v)
+	}
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			add(k, v)
+		}
+	}
+	for k, vs := range form {
+		for _, v := range vs {
+			add(k, v)
+		}
+	}
+	sort.Strings(pairs)
+
+	baseURL := *req.URL
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	baseString := req.Method + "&" + oauthEscape(baseURL.String()) + "&" + oauthEscape(strings.Join(pairs, "&"))
+	key := oauthEscape(s.creds.ConsumerSecret) + "&" + oauthEscape(s.creds.AccessSecret)
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(baseString))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	keys := make([]string, 0, len(oauthParams))
+	for k := range oauthParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	header := make([]string, 0, len(keys))
+	for _, k := range keys {
+		header = append(header, fmt.Sprintf("%s=%q", oauthEscape(k), oauthEscape(oauthParams[k])))
+	}
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+}
@@ -0,0 +1,84 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// namespaceKey normalizes a namespace name for lookups; MediaWiki matches
+// them case-insensitively and treats underscores as spaces.
+func namespaceKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", " "))
+}
+
+// splitNamespace splits a title prefix into its namespace ID and the rest.
+// A prefix whose part before the first colon is not a namespace of the wiki
+// belongs to the main namespace.
+func (c *MediaWikiClient) splitNamespace(ctx context.Context, prefix string) (int, string, error) {
+	name, rest, ok := strings.Cut(prefix, ":")
+	if !ok {
+		return 0, prefix, nil
+	}
+	namespaces, err := c.loadNamespaces(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	if id, ok := namespaces[namespaceKey(name)]; ok {
+		return id, rest, nil
+	}
+	return 0, prefix, nil
+}
+
+// loadNamespaces returns the wiki's namespace names, canonical names and
+// aliases mapped to their IDs. They are read from meta=siteinfo once.
+func (c *MediaWikiClient) loadNamespaces(ctx context.Context) (map[string]int, error) {
+	c.nsMu.Lock()
+	defer c.nsMu.Unlock()
+	if c.namespaces != nil {
+		return c.namespaces, nil
+	}
+	result, err := c.apiRequest(ctx, map[string]string{
+		"action": "query",
+		"meta":   "siteinfo",
+		"siprop": "namespaces|namespacealiases",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get namespaces: %w", err)
+	}
+	query, _ := result["query"].(map[string]any)
+	list, ok := query["namespaces"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("get namespaces: invalid response: missing namespaces")
+	}
+	namespaces := make(map[string]int, len(list))
+	for key, raw := range list {
+		ns, _ := raw.(map[string]any)
+		id, err := strconv.Atoi(key)
+		if ns == nil || err != nil {
+			continue
+		}
+		// formatversion=1 has the local name in "*", formatversion=2 in "name"
+		for _, field := range []string{"*", "name", "canonical"} {
+			if name, _ := ns[field].(string); name != "" {
+				namespaces[namespaceKey(name)] = id
+			}
+		}
+	}
+	aliases, _ := query["namespacealiases"].([]any)
+	for _, raw := range aliases {
+		alias, _ := raw.(map[string]any)
+		id, ok := alias["id"].(float64)
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"*", "alias"} {
+			if name, _ := alias[field].(string); name != "" {
+				namespaces[namespaceKey(name)] = int(id)
+			}
+		}
+	}
+	c.namespaces = namespaces
+	return namespaces, nil
+}
@@ -0,0 +1,144 @@
+package mediawiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// RequiredRights are the user rights the connector needs to maintain pages:
+// editing, flagging edits as bot edits and deleting stale version pages.
+var RequiredRights = []string{"edit", "bot", "delete"}
+
+// ErrMissingRights is returned (wrapped) by VerifyRights when the session is
+// anonymous or lacks a required right, as opposed to the check failing.
+var ErrMissingRights = errors.New("missing wiki rights")
+
+// UserInfo describes the account the client acts as (meta=userinfo).
+type UserInfo struct {
+	ID        int64
+	Name      string
+	Anonymous bool
+	Rights    []string
+	Groups    []string
+}
+
+// HasRight reports whether the account has the given user right.
+func (u *UserInfo) HasRight(right string) bool {
+	return slices.Contains(u.Rights, right)
+}
+
+// UserInfo returns the account of the current session.
+func (c *MediaWikiClient) UserInfo(ctx context.Context) (*UserInfo, error) {
+	result, err := c.apiRequest(ctx, map[string]string{
+		"action": "query",
+		"meta":   "userinfo",
+		"uiprop": "rights|groups",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get user info: %w", err)
+	}
+	query, _ := result["query"].(map[string]any)
+	ui, ok := query["userinfo"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid response: missing userinfo")
+	}
+	info := &UserInfo{}
+	info.Name, _ = ui["name"].(string)
+	if id, ok := ui["id"].(float64); ok {
+		info.ID = int64(id)
+	}
+	_, info.Anonymous = ui["anon"]
+	info.Rights = stringList(ui["rights"])
+	info.Groups = stringList(ui["groups"])
+	return info, nil
+}
+
+// Ping checks that the wiki answers and accepts edits; it fails while the
+// wiki is in read-only mode, e.g. during maintenance.
+func (c *MediaWikiClient) Ping(ctx context.Context) error {
+	result, err := c.apiRequest(ctx, map[string]string{
+		"action": "query",
+		"meta":   "siteinfo",
+		"siprop": "general",
+	})
+	if err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	query, _ := result["query"].(map[string]any)
+	general, ok := query["general"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("ping: invalid response: missing general")
+	}
+	if _, readOnly := general["readonly"]; readOnly {
+		reason, _ := general["readonlyreason"].(string)
+		return fmt.Errorf("ping: %w: %s", pagestore.ErrReadOnly, reason)
+	}
+	return nil
+}
+
+// VerifyRights checks that the session is logged in and holds every right in
+// required. When the account has the bot right, subsequent writes assert
+// bot instead of user.
+func (c *MediaWikiClient) VerifyRights(ctx context.Context, required ...string) (*UserInfo, error) {
+	info, err := c.UserInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if info.Anonymous {
+		return info, fmt.Errorf("%w: wiki session is not logged in; writes would be attributed to the server IP", ErrMissingRights)
+	}
+	var missing []string
+	for _, r := range required {
+		if !info.HasRight(r) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		return info, fmt.Errorf("%w: wiki account %q lacks required rights: %s (groups: %s)",
+			ErrMissingRights, info.Name, strings.Join(missing, ", "), strings.Join(info.Groups, ", "))
+	}
+
+	c.authMu.Lock()
+	if info.HasRight("bot") {
+		c.assertion = "bot"
+	} else {
+		c.assertion = "user"
+	}
+	c.authMu.Unlock()
+	c.logger.InfoContext(ctx, "wiki rights verified", "user", info.Name, "groups", info.Groups)
+	return info, nil
+}
+
+// writeAssertion returns the value for the assert parameter of write
+// requests, or "" when no credentials are configured.
+func (c *MediaWikiClient) writeAssertion() string {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	return c.assertion
+}
+
+// isAssertFailedError reports whether a write was refused because the
+// session no longer belongs to the expected account.
+func isAssertFailedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "assertuserfailed") || strings.Contains(msg, "assertbotfailed")
+}
+
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		if s, ok := it.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
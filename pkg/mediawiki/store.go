@@ -0,0 +1,278 @@
+package mediawiki
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// MediaWikiClient implements pagestore.PageStore on top of the action API.
+var _ pagestore.PageStore = (*MediaWikiClient)(nil)
+
+// maxTitlesPerQuery is the MediaWiki limit for titles= in a single query for
+// accounts without apihighlimits.
+const maxTitlesPerQuery = 50
+
+// revisionQueryParams returns the query parameters to read the latest revision
+// of the given titles ("|"-separated) including content and metadata.
+func revisionQueryParams(titles string) map[string]string {
+	return map[string]string{
+		"action":  "query",
+		"titles":  titles,
+		"prop":    "revisions",
+		"rvprop":  "content|ids|timestamp|user|comment|flags",
+		"rvslots": "main",
+	}
+}
+
+// parseRevisionPage converts a page object of a revisions query into a Page.
+// ok is false for missing pages.
+func parseRevisionPage(pageMap map[string]any) (page *pagestore.Page, ok bool, err error) {
+	title, _ := pageMap["title"].(string)
+	if _, missing := pageMap["missing"]; missing {
+		return nil, false, nil
+	}
+	revisions, _ := pageMap["revisions"].([]any)
+	if len(revisions) == 0 {
+		return nil, false, fmt.Errorf("no revisions found for page: %s", title)
+	}
+	rev, _ := revisions[0].(map[string]any)
+	slots, _ := rev["slots"].(map[string]any)
+	main, _ := slots["main"].(map[string]any)
+	content, _ := main["*"].(string)
+
+	p := &pagestore.Page{Title: title, Content: content}
+	if id, ok := rev["revid"].(float64); ok {
+		p.Revision.ID = int64(id)
+	}
+	if ts, _ := rev["timestamp"].(string); ts != "" {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			p.Revision.Timestamp = t
+		}
+	}
+	p.Revision.User, _ = rev["user"].(string)
+	p.Revision.Summary, _ = rev["comment"].(string)
+	_, p.Revision.Bot = rev["bot"]
+	return p, true, nil
+}
+
+// Get returns the current revision of a page via action=query.
+func (c *MediaWikiClient) Get(ctx context.Context, title string) (*pagestore.Page, error) {
+	result, err := c.apiRequest(ctx, revisionQueryParams(title))
+	if err != nil {
+		return nil, fmt.Errorf("get page content for %s: %w", title, err)
+	}
+	query, ok := result["query"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid response structure: missing query")
+	}
+	pages, ok := query["pages"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid response structure: missing pages")
+	}
+	for _, page := range pages {
+		pageMap, _ := page.(map[string]any)
+		if pageMap == nil {
+			continue
+		}
+		p, exists, err := parseRevisionPage(pageMap)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", pagestore.ErrNotFound, title)
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("could not extract content from page: %s", title)
+}
+
+// GetMany reads pages in batches of maxTitlesPerQuery titles.
+func (c *MediaWikiClient) GetMany(ctx context.Context, titles []string) (map[string]*pagestore.Page, error) {
+	out := make(map[string]*pagestore.Page, len(titles))
+	for start := 0; start < len(titles); start += maxTitlesPerQuery {
+		batch := titles[start:min(start+maxTitlesPerQuery, len(titles))]
+		result, err := c.apiRequest(ctx, revisionQueryParams(strings.Join(batch, "|")))
+		if err != nil {
+			return nil, fmt.Errorf("get %d pages: %w", len(batch), err)
+		}
+		query, ok := result["query"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid response structure: missing query")
+		}
+		// map the wiki's canonical titles back to the requested ones
+		requested := make(map[string][]string, len(batch))
+		for _, t := range batch {
+			requested[pagestore.NormalizeTitle(t)] = append(requested[pagestore.NormalizeTitle(t)], t)
+		}
+		if normalized, ok := query["normalized"].([]any); ok {
+			for _, n := range normalized {
+				nm, _ := n.(map[string]any)
+				from, _ := nm["from"].(string)
+				to, _ := nm["to"].(string)
+				if from != "" && to != "" {
+					requested[pagestore.NormalizeTitle(to)] = append(requested[pagestore.NormalizeTitle(to)], from)
+				}
+			}
+		}
+		pages, _ := query["pages"].(map[string]any)
+		for _, page := range pages {
+			pageMap, _ := page.(map[string]any)
+			if pageMap == nil {
+				continue
+			}
+			p, exists, err := parseRevisionPage(pageMap)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				continue
+			}
+			for _, t := range requested[pagestore.NormalizeTitle(p.Title)] {
+				out[t] = p
+			}
+		}
+	}
+	return out, nil
+}
+
+// Put writes a page via action=edit, retrying once on a stale CSRF token or
+// a lost session.
+func (c *MediaWikiClient) Put(ctx context.Context, title, content string, opts pagestore.PutOptions) error {
+	return c.withCSRFWriteRetry(ctx, func(csrf string) error {
+		params := map[string]string{
+			"action":  "edit",
+			"title":   title,
+			"text":    content,
+			"summary": opts.Summary,
+			"token":   csrf,
+		}
+		if opts.Bot {
+			params["bot"] = "true"
+		}
+		if opts.BaseRevision != 0 {
+			params["baserevid"] = strconv.FormatInt(opts.BaseRevision, 10)
+		}
+		if opts.CreateOnly {
+			params["createonly"] = "true"
+		}
+		if a := c.writeAssertion(); a != "" {
+			params["assert"] = a
+		}
+		result, err := c.apiRequest(ctx, params)
+		if isConflictError(err) {
+			return fmt.Errorf("%w: %s: %v", pagestore.ErrConflict, title, err)
+		}
+		if err != nil {
+			return fmt.Errorf("edit request failed: %w", err)
+		}
+		edit, ok := result["edit"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid edit response structure")
+		}
+		if r, _ := edit["result"].(string); r != "Success" {
+			return fmt.Errorf("edit failed: %s", r)
+		}
+		c.logger.InfoContext(ctx, "wiki edit success", "title", title, "bot", opts.Bot)
+		return nil
+	})
+}
+
+// Delete deletes a page via action=delete.
+func (c *MediaWikiClient) Delete(ctx context.Context, title, reason string) error {
+	return c.withCSRFWriteRetry(ctx, func(csrf string) error {
+		params := map[string]string{
+			"action": "delete",
+			"title":  title,
+			"token":  csrf,
+		}
+		if reason != "" {
+			params["reason"] = reason
+		}
+		if a := c.writeAssertion(); a != "" {
+			params["assert"] = a
+		}
+		result, err := c.apiRequest(ctx, params)
+		if err != nil {
+			if strings.Contains(err.Error(), "missingtitle") {
+				return nil
+			}
+			return fmt.Errorf("delete request failed: %w", err)
+		}
+		if _, ok := result["delete"].(map[string]any); !ok {
+			return fmt.Errorf("invalid delete response structure")
+		}
+		c.logger.InfoContext(ctx, "wiki delete success", "title", title)
+		return nil
+	})
+}
+
+// List retrieves all pages with the specified prefix via list=allpages,
+// handling namespaces and pagination. The namespace of the prefix is
+// resolved against the wiki's namespaces and aliases.
+func (c *MediaWikiClient) List(ctx context.Context, prefix string) ([]string, error) {
+	var allPages []string
+	apcontinue := ""
+
+	nsID, actualPrefix, err := c.splitNamespace(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("get pages with prefix %s: %w", prefix, err)
+	}
+	namespace := strconv.Itoa(nsID)
+
+	for {
+		params := map[string]string{
+			"action":      "query",
+			"list":        "allpages",
+			"apnamespace": namespace,
+			"apprefix":    actualPrefix,
+			"aplimit":     "500",
+		}
+		if apcontinue != "" {
+			params["apcontinue"] = apcontinue
+		}
+		result, err := c.apiRequest(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("get pages with prefix %s: %w", prefix, err)
+		}
+		query, ok := result["query"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid response structure: missing query")
+		}
+		pages, ok := query["allpages"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid response structure: missing allpages")
+		}
+		for _, p := range pages {
+			pm, _ := p.(map[string]any)
+			if pm == nil {
+				continue
+			}
+			title, _ := pm["title"].(string)
+			if title != "" {
+				allPages = append(allPages, title)
+			}
+		}
+		if cont, ok := result["continue"].(map[string]any); ok {
+			if apc, _ := cont["apcontinue"].(string); apc != "" {
+				apcontinue = apc
+				continue
+			}
+		}
+		break
+	}
+	return allPages, nil
+}
+
+// isConflictError matches the errors of a conditional edit that lost a race.
+func isConflictError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "editconflict") || strings.Contains(msg, "articleexists")
+}
@@ -0,0 +1,285 @@
+package pagestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/atomicfile"
+)
+
+const (
+	wikitextExt = ".wikitext"
+	metaExt     = ".meta.json"
+
+	// mainNamespaceDir holds pages without a namespace prefix.
+	mainNamespaceDir = "Main"
+)
+
+// namespaces are the title prefixes mapped to their own top-level directory.
+var namespaces = []string{"Template", "Project", "User", "Help", "Category", "Module", "File", "MediaWiki"}
+
+// FileStore is a PageStore that mirrors the wiki on the local filesystem.
+//
+// Titles are normalized the way MediaWiki does (underscores become spaces) and
+// split on "/" into directories below a per-namespace directory, so
+// "Template:VPM/foo/1.0.0" is stored as "Template/VPM/foo/1.0.0.wikitext" with
+// a "1.0.0.meta.json" sidecar next to it. Characters that are not portable in
+// file names are percent-encoded, which keeps the mapping reversible; an empty
+// segment, as in "A//B", is stored as "%". Content and sidecar are each
+// replaced atomically.
+type FileStore struct {
+	root string
+	mu   sync.Mutex
+}
+
+// fileMeta is the sidecar stored next to every page.
+type fileMeta struct {
+	Title    string   `json:"title"`
+	Revision Revision `json:"revision"`
+}
+
+// NewFileStore returns a FileStore rooted at dir. The directory is created on first write.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{root: dir}
+}
+
+// emptySegment stands for an empty title segment. A lone "%" is never
+// produced for a non-empty segment, since "%" itself is escaped.
+const emptySegment = "%"
+
+// escapeSegment percent-encodes bytes that are unsafe in a file name.
+func escapeSegment(seg string) string {
+	switch seg {
+	case "":
+		return emptySegment
+	case ".", "..":
+		return strings.ReplaceAll(seg, ".", "%2E")
+	}
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		ch := seg[i]
+		if ch < 32 || ch == 127 || strings.IndexByte(`<>:"/\|?*%`, ch) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", ch)
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// unescapeSegment reverses escapeSegment.
+func unescapeSegment(seg string) (string, error) {
+	if seg == emptySegment {
+		return "", nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		if seg[i] != '%' {
+			b.WriteByte(seg[i])
+			continue
+		}
+		if i+2 >= len(seg) {
+			return "", fmt.Errorf("invalid escape in %q", seg)
+		}
+		ch, err := strconv.ParseUint(seg[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q: %w", seg, err)
+		}
+		b.WriteByte(byte(ch))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// splitNamespace returns the namespace directory and the title without it.
+func splitNamespace(title string) (string, string) {
+	for _, ns := range namespaces {
+		if rest, ok := strings.CutPrefix(title, ns+":"); ok {
+			return ns, rest
+		}
+	}
+	return mainNamespaceDir, title
+}
+
+// pagePath returns the path of a page without file extension.
+func (s *FileStore) pagePath(title string) string {
+	ns, rest := splitNamespace(NormalizeTitle(title))
+	parts := strings.Split(rest, "/")
+	elems := make([]string, 0, len(parts)+2)
+	elems = append(elems, s.root, ns)
+	for _, p := range parts {
+		elems = append(elems, escapeSegment(p))
+	}
+	return filepath.Join(elems...)
+}
+
+// titleFromPath reverses pagePath for a path relative to the root.
+func titleFromPath(rel string) (string, error) {
+	rel = strings.TrimSuffix(filepath.ToSlash(rel), wikitextExt)
+	parts := strings.Split(rel, "/")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("page outside namespace directory: %s", rel)
+	}
+	segs := make([]string, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		seg, err := unescapeSegment(p)
+		if err != nil {
+			return "", err
+		}
+		segs = append(segs, seg)
+	}
+	title := strings.Join(segs, "/")
+	if parts[0] != mainNamespaceDir {
+		title = parts[0] + ":" + title
+	}
+	return title, nil
+}
+
+func (s *FileStore) readMeta(base string) (fileMeta, error) {
+	var meta fileMeta
+	data, err := os.ReadFile(base + metaExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return meta, nil
+		}
+		return meta, fmt.Errorf("read metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("parse metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// Get returns the stored page content and its sidecar metadata.
+func (s *FileStore) Get(ctx context.Context, title string) (*Page, error) {
+	base := s.pagePath(title)
+	data, err := os.ReadFile(base + wikitextExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, title)
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	meta, err := s.readMeta(base)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: NormalizeTitle(title), Content: string(data), Revision: meta.Revision}, nil
+}
+
+// GetMany reads the existing pages among titles.
+func (s *FileStore) GetMany(ctx context.Context, titles []string) (map[string]*Page, error) {
+	out := make(map[string]*Page, len(titles))
+	for _, t := range titles {
+		p, err := s.Get(ctx, t)
+		if err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		out[t] = p
+	}
+	return out, nil
+}
+
+// Put writes the page content and bumps the revision in its sidecar.
+func (s *FileStore) Put(ctx context.Context, title, content string, opts PutOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := s.pagePath(title)
+	if err := os.MkdirAll(filepath.Dir(base), 0o755); err != nil {
+		return fmt.Errorf("ensure page dir: %w", err)
+	}
+	meta, err := s.readMeta(base)
+	if err != nil {
+		return err
+	}
+	if opts.CreateOnly || opts.BaseRevision != 0 {
+		var cur *Page
+		if _, err := os.Stat(base + wikitextExt); err == nil {
+			cur = &Page{Revision: meta.Revision}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("stat file: %w", err)
+		}
+		if err := checkPrecondition(cur, title, opts); err != nil {
+			return err
+		}
+	}
+	meta.Title = NormalizeTitle(title)
+	meta.Revision = Revision{
+		ID:        meta.Revision.ID + 1,
+		Timestamp: time.Now().UTC(),
+		Summary:   opts.Summary,
+		Bot:       opts.Bot,
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	if err := atomicfile.WriteFile(base+wikitextExt, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := atomicfile.WriteFile(base+metaExt, append(metaData, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the page and its sidecar. Missing pages are not an error.
+func (s *FileStore) Delete(ctx context.Context, title, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := s.pagePath(title)
+	for _, p := range []string{base + wikitextExt, base + metaExt} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete file: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns the titles of all stored pages starting with prefix, sorted.
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	prefix = NormalizeTitle(prefix)
+	var titles []string
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, wikitextExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		title, err := titleFromPath(rel)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(title, prefix) {
+			titles = append(titles, title)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pages with prefix %s: %w", prefix, err)
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
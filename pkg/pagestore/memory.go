@@ -0,0 +1,93 @@
+package pagestore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory PageStore, mainly useful for tests and previews.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	pages map[string]*Page
+	next  int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{pages: make(map[string]*Page)}
+}
+
+// Get returns a copy of the stored page.
+func (m *MemoryStore) Get(ctx context.Context, title string) (*Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.pages[NormalizeTitle(title)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, title)
+	}
+	cp := *p
+	return &cp, nil
+}
+
+// GetMany returns copies of the existing pages among titles.
+func (m *MemoryStore) GetMany(ctx context.Context, titles []string) (map[string]*Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]*Page, len(titles))
+	for _, t := range titles {
+		if p, ok := m.pages[NormalizeTitle(t)]; ok {
+			cp := *p
+			out[t] = &cp
+		}
+	}
+	return out, nil
+}
+
+// Put stores the page as a new revision.
+func (m *MemoryStore) Put(ctx context.Context, title, content string, opts PutOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := NormalizeTitle(title)
+	if err := checkPrecondition(m.pages[key], title, opts); err != nil {
+		return err
+	}
+	m.next++
+	m.pages[key] = &Page{
+		Title:   key,
+		Content: content,
+		Revision: Revision{
+			ID:        m.next,
+			Timestamp: time.Now().UTC(),
+			Summary:   opts.Summary,
+			Bot:       opts.Bot,
+		},
+	}
+	return nil
+}
+
+// Delete removes the page.
+func (m *MemoryStore) Delete(ctx context.Context, title, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pages, NormalizeTitle(title))
+	return nil
+}
+
+// List returns the titles starting with prefix, sorted.
+func (m *MemoryStore) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefix = NormalizeTitle(prefix)
+	var titles []string
+	for t := range m.pages {
+		if strings.HasPrefix(t, prefix) {
+			titles = append(titles, t)
+		}
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
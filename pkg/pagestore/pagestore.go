@@ -0,0 +1,99 @@
+// Package pagestore defines the storage interface the wiki sync engine works
+// against, together with filesystem and in-memory implementations. The
+// MediaWiki API implementation lives in package mediawiki.
+package pagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned (wrapped) by Get when a page does not exist.
+var ErrNotFound = errors.New("page does not exist")
+
+// ErrConflict is returned (wrapped) by a conditional Put whose precondition
+// no longer holds because someone else edited or created the page.
+var ErrConflict = errors.New("edit conflict")
+
+// ErrReadOnly is returned (wrapped) by writes while the wiki is in read-only
+// mode, e.g. during maintenance.
+var ErrReadOnly = errors.New("wiki is read-only")
+
+// Revision describes the stored revision of a page.
+type Revision struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Bot       bool      `json:"bot,omitempty"`
+}
+
+// Page is a page with its current content and revision metadata.
+type Page struct {
+	Title    string
+	Content  string
+	Revision Revision
+}
+
+// PutOptions carries the edit metadata of a write.
+type PutOptions struct {
+	Summary string
+	Bot     bool
+	// BaseRevision, if non-zero, makes the write fail with ErrConflict unless
+	// it is the current revision of the page.
+	BaseRevision int64
+	// CreateOnly makes the write fail with ErrConflict if the page exists.
+	CreateOnly bool
+}
+
+// PageStore reads and writes wiki pages. Titles are full MediaWiki titles
+// including the namespace prefix, e.g. "Template:VPM/foo/Latest_version";
+// implementations treat underscores and spaces as equivalent.
+type PageStore interface {
+	// Get returns a page or an error wrapping ErrNotFound.
+	Get(ctx context.Context, title string) (*Page, error)
+	// GetMany returns the existing pages among titles keyed by the requested
+	// title. Missing pages are omitted.
+	GetMany(ctx context.Context, titles []string) (map[string]*Page, error)
+	// Put creates or replaces a page.
+	Put(ctx context.Context, title, content string, opts PutOptions) error
+	// Delete removes a page. Deleting a missing page is not an error.
+	Delete(ctx context.Context, title, reason string) error
+	// List returns the titles of all pages starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NormalizeTitle maps underscores to spaces the way MediaWiki does for titles.
+func NormalizeTitle(title string) string {
+	return strings.ReplaceAll(strings.TrimSpace(title), "_", " ")
+}
+
+// IsNotFound reports whether err means the page does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// checkPrecondition enforces the conditions of opts against the current page,
+// which is nil when the page does not exist.
+func checkPrecondition(cur *Page, title string, opts PutOptions) error {
+	if opts.CreateOnly && cur != nil {
+		return fmt.Errorf("%w: %s already exists", ErrConflict, title)
+	}
+	if opts.BaseRevision != 0 && (cur == nil || cur.Revision.ID != opts.BaseRevision) {
+		return fmt.Errorf("%w: %s changed since revision %d", ErrConflict, title, opts.BaseRevision)
+	}
+	return nil
+}
+
+// IsConflict reports whether err means a conditional write lost a race.
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsReadOnly reports whether err means the wiki does not accept writes.
+func IsReadOnly(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
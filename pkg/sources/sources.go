@@ -0,0 +1,155 @@
+// Package sources describes where package metadata comes from: VPMM
+// instances (index plus SSE stream) and plain VCC repository listings that are
+// polled. Listings of several sources are merged with a fixed precedence.
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+)
+
+// Source kinds.
+const (
+	// KindVPMM is a VPMM instance serving /index.json and the /sse stream.
+	KindVPMM = "vpmm"
+	// KindListing is a static VCC repository listing URL without SSE.
+	KindListing = "listing"
+)
+
+// DefaultPollInterval is used for listing sources without a poll interval.
+const DefaultPollInterval = 15 * time.Minute
+
+// Config configures one source. Sources listed earlier take precedence when
+// several provide the same package.
+type Config struct {
+	// Name identifies the source in logs; defaults to the URL host.
+	Name string `json:"name"`
+	// Kind is KindVPMM or KindListing; defaults to KindVPMM.
+	Kind string `json:"kind"`
+	// URL is the VPMM base URL or the listing URL.
+	URL string `json:"url"`
+	// PollInterval is how often a listing source is polled, e.g. "10m".
+	PollInterval string `json:"pollInterval,omitempty"`
+}
+
+// ParseConfigs parses a JSON array of source configs.
+func ParseConfigs(raw string) ([]Config, error) {
+	var configs []Config
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("parse sources: %w", err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("parse sources: no sources configured")
+	}
+	return configs, nil
+}
+
+// Source is a configured origin of repository listings.
+type Source struct {
+	Name         string
+	Kind         string
+	URL          string
+	PollInterval time.Duration
+
+	api        *apiclient.ClientWithResponses
+	httpClient apiclient.HttpRequestDoer
+}
+
+// New validates cfg and returns the Source. httpClient (e.g. an
+// apiclient.RetryingDoer) is used for index and listing requests.
+func New(cfg Config, httpClient apiclient.HttpRequestDoer) (*Source, error) {
+	u, err := url.Parse(strings.TrimSpace(cfg.URL))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("source %q: invalid url %q", cfg.Name, cfg.URL)
+	}
+	s := &Source{
+		Name:       strings.TrimSpace(cfg.Name),
+		Kind:       strings.ToLower(strings.TrimSpace(cfg.Kind)),
+		URL:        u.String(),
+		httpClient: httpClient,
+	}
+	if s.Name == "" {
+		s.Name = u.Host
+	}
+	switch s.Kind {
+	case "", KindVPMM:
+		s.Kind = KindVPMM
+		s.api, err = apiclient.NewClientWithResponses(s.URL, apiclient.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, fmt.Errorf("source %q: init api client: %w", s.Name, err)
+		}
+	case KindListing:
+		s.PollInterval = DefaultPollInterval
+		if cfg.PollInterval != "" {
+			s.PollInterval, err = time.ParseDuration(cfg.PollInterval)
+			if err != nil || s.PollInterval <= 0 {
+				return nil, fmt.Errorf("source %q: invalid poll interval %q", s.Name, cfg.PollInterval)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("source %q: unknown kind %q", s.Name, cfg.Kind)
+	}
+	return s, nil
+}
+
+// SSEURL returns the event stream URL, or "" for sources without one.
+func (s *Source) SSEURL() string {
+	if s.Kind != KindVPMM {
+		return ""
+	}
+	return strings.TrimRight(s.URL, "/") + "/sse"
+}
+
+// Fetch downloads the source's listing unless it still matches prev, in which
+// case it returns apiclient.ErrIndexNotModified.
+func (s *Source) Fetch(ctx context.Context, prev apiclient.IndexValidators) (*apiclient.RepositoryListing, apiclient.IndexValidators, error) {
+	if s.Kind == KindVPMM {
+		return s.api.FetchIndexIfChanged(ctx, prev)
+	}
+	return apiclient.FetchListing(ctx, s.httpClient, s.URL, prev)
+}
+
+// Merge combines listings ordered by precedence (highest first) into one.
+// A package is taken as a whole from the first listing that contains it.
+// The returned origins map each package name to the index of that listing.
+func Merge(listings []*apiclient.RepositoryListing) (*apiclient.RepositoryListing, map[string]int) {
+	merged := &apiclient.RepositoryListing{Packages: map[string]apiclient.ListingPackage{}}
+	origins := map[string]int{}
+	for i, l := range listings {
+		if l == nil {
+			continue
+		}
+		for name, pkg := range l.Packages {
+			if _, ok := merged.Packages[name]; ok {
+				continue
+			}
+			merged.Packages[name] = pkg
+			origins[name] = i
+		}
+	}
+	return merged, origins
+}
+
+// NewAll builds the sources of configs in order. Source names must be unique.
+func NewAll(configs []Config, httpClient apiclient.HttpRequestDoer) ([]*Source, error) {
+	out := make([]*Source, 0, len(configs))
+	seen := map[string]bool{}
+	for _, cfg := range configs {
+		s, err := New(cfg, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate source name %q", s.Name)
+		}
+		seen[s.Name] = true
+		out = append(out, s)
+	}
+	return out, nil
+}
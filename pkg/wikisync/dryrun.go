@@ -0,0 +1,55 @@
+package wikisync
+
+import (
+	"slices"
+	"strings"
+)
+
+// dryRun makes a Syncer log its writes instead of making them; see
+// WithDryRun.
+type dryRun struct {
+	// canary lists the package name globs whose pages are still written
+	canary []string
+}
+
+// WithDryRun returns a copy of s that only logs the edits and deletions it
+// would make. Pages of packages matching one of the canary globs (see
+// Filter) are still written, so a rendering change can be rolled out to a
+// few packages while its effect on all others is reviewed in the log.
+// Reads are unaffected.
+func (s *Syncer) WithDryRun(canary ...string) *Syncer {
+	cp := *s
+	cp.dryRun = &dryRun{canary: slices.Clone(canary)}
+	return &cp
+}
+
+// DryRun reports whether the Syncer was made with WithDryRun.
+func (s *Syncer) DryRun() bool {
+	return s.dryRun != nil
+}
+
+// skipWrite reports whether a write of title must only be logged. Pages that
+// do not belong to a package, like the version summary, are written only
+// outside of dry runs.
+func (s *Syncer) skipWrite(title string) bool {
+	if s.dryRun == nil {
+		return false
+	}
+	name := titlePackage(title)
+	return name == "" || !matchAny(s.dryRun.canary, []string{name})
+}
+
+// titlePackage returns the package a Template:VPM/<package>/... page belongs
+// to, or "" for other pages.
+func titlePackage(title string) string {
+	const prefix = "Template:VPM/"
+	rest, ok := strings.CutPrefix(title, prefix)
+	if !ok {
+		return ""
+	}
+	name, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(name)
+}
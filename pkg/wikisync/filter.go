@@ -0,0 +1,131 @@
+package wikisync
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	apiclient "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+)
+
+// Filter selects the packages a Syncer touches. Patterns are globs where *
+// matches any run of characters and ? a single one, compared
+// case-insensitively. A package is selected when it matches at least one
+// pattern of every non-empty include list and no exclude pattern.
+type Filter struct {
+	// Include and Exclude match package names.
+	Include []string
+	Exclude []string
+	// IncludeAuthors and ExcludeAuthors match any of a package's authors.
+	IncludeAuthors []string
+	ExcludeAuthors []string
+	// IncludeRepositories and ExcludeRepositories match the ID, name or URL
+	// of the repository a package was taken from.
+	IncludeRepositories []string
+	ExcludeRepositories []string
+}
+
+// IsZero reports whether f selects every package.
+func (f Filter) IsZero() bool {
+	return len(f.Include)+len(f.Exclude)+len(f.IncludeAuthors)+len(f.ExcludeAuthors)+
+		len(f.IncludeRepositories)+len(f.ExcludeRepositories) == 0
+}
+
+// Match reports whether f selects the package name. pkg is its latest
+// version, nil for packages only found on the wiki, and repo its origin, zero
+// if unknown. Packages without metadata never match an author or repository
+// include list.
+func (f Filter) Match(name string, pkg *apiclient.Package, repo Repository) bool {
+	names := []string{name}
+	var authors []string
+	if pkg != nil && pkg.Author.Name != nil {
+		for author := range strings.SplitSeq(*pkg.Author.Name, ",") {
+			if author = strings.TrimSpace(author); author != "" {
+				authors = append(authors, author)
+			}
+		}
+	}
+	var repos []string
+	for _, r := range []string{repo.ID, repo.Name, repo.URL} {
+		if r != "" {
+			repos = append(repos, r)
+		}
+	}
+	return selects(f.Include, f.Exclude, names) &&
+		selects(f.IncludeAuthors, f.ExcludeAuthors, authors) &&
+		selects(f.IncludeRepositories, f.ExcludeRepositories, repos)
+}
+
+func selects(include, exclude, values []string) bool {
+	if len(include) > 0 && !matchAny(include, values) {
+		return false
+	}
+	return !matchAny(exclude, values)
+}
+
+func matchAny(patterns, values []string) bool {
+	for _, p := range patterns {
+		for _, v := range values {
+			if globMatch(strings.ToLower(p), strings.ToLower(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatch reports whether s matches pattern, where * matches any run of
+// characters, including slashes, and ? a single character.
+func globMatch(pattern, s string) bool {
+	// backtrack to the last * when the rest does not match
+	starP, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, i
+				p++
+				continue
+			case '?':
+				_, size := utf8.DecodeRuneInString(s[i:])
+				p++
+				i += size
+				continue
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(s[starS:])
+		starS += size
+		p, i = starP+1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// WithFilter returns a copy of s that only syncs the packages f selects, in
+// SyncExistingPages and for callers asking Selects.
+func (s *Syncer) WithFilter(f Filter) *Syncer {
+	cp := *s
+	cp.filter = f
+	return &cp
+}
+
+// Selects reports whether the Syncer's filter selects the package name with
+// latest version pkg (nil if unknown), using the repository recorded with
+// WithRepositories.
+func (s *Syncer) Selects(name string, pkg *apiclient.Package) bool {
+	if s.filter.IsZero() {
+		return true
+	}
+	return s.filter.Match(name, pkg, s.repositories[name])
+}
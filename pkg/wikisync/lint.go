@@ -0,0 +1,231 @@
+package wikisync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	apiclient "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// LintCategory classifies a Template:VPM/* page found during a maintenance scan.
+type LintCategory string
+
+const (
+	LintValid             LintCategory = "valid"
+	LintNonSemverContent  LintCategory = "non_semver_content"
+	LintUnknownVersion    LintCategory = "unknown_version"
+	LintUnknownPackage    LintCategory = "unknown_package"
+	LintUnexpectedSubpage LintCategory = "unexpected_subpage"
+	LintMissingSubpages   LintCategory = "missing_subpages"
+)
+
+// lintCategoryOrder is the order in which categories are rendered.
+var lintCategoryOrder = []LintCategory{
+	LintNonSemverContent,
+	LintUnknownVersion,
+	LintUnknownPackage,
+	LintUnexpectedSubpage,
+	LintMissingSubpages,
+	LintValid,
+}
+
+// LintFinding is the classification of a single wiki page.
+type LintFinding struct {
+	Title    string       `json:"title"`
+	Package  string       `json:"package,omitempty"`
+	Category LintCategory `json:"category"`
+	Detail   string       `json:"detail,omitempty"`
+}
+
+// LintReport is the result of LintVpmPages. Findings are sorted by title.
+type LintReport struct {
+	Findings []LintFinding        `json:"findings"`
+	Counts   map[LintCategory]int `json:"counts"`
+}
+
+// requiredSubpages are the subpages every version (or Latest_*) page is expected to have.
+var requiredSubpages = []string{"Description", "DisplayName", "License"}
+
+// isKnownSubpage reports whether name is a subpage the connector manages.
+func isKnownSubpage(name string) bool {
+	name = pagestore.NormalizeTitle(name)
+	if slices.Contains(requiredSubpages, name) || slices.Contains(repositorySubpages, name) {
+		return true
+	}
+	for i := 1; i <= 4; i++ {
+		if name == fmt.Sprintf("Author %d", i) {
+			return true
+		}
+	}
+	return false
+}
+
+// LintVpmPages classifies every Template:VPM/* page on the wiki against the
+// known package versions. Pages owned by the connector itself (the version
+// summary and any title listed in skip) are ignored, as are the pages of
+// packages the Syncer's filter does not select.
+func (s *Syncer) LintVpmPages(ctx context.Context, allVersionsMap map[string][]apiclient.Package, skip ...string) (*LintReport, error) {
+	pages, err := s.store.List(ctx, "Template:VPM/")
+	if err != nil {
+		return nil, err
+	}
+	latestMap, _, _ := ComputeLatestStableUnstable(allVersionsMap)
+	selected := func(pkg string) bool {
+		var latest *apiclient.Package
+		if v, ok := latestMap[pkg]; ok {
+			latest = &v
+		}
+		return s.Selects(pkg, latest)
+	}
+	pages = slices.DeleteFunc(pages, func(title string) bool {
+		pkg, _, _ := parseVPMPageTitle(title)
+		return pkg != "" && !selected(pkg)
+	})
+
+	skipSet := map[string]struct{}{pagestore.NormalizeTitle(VersionSummaryPageTitle): {}}
+	for _, title := range skip {
+		skipSet[pagestore.NormalizeTitle(title)] = struct{}{}
+	}
+	existing := make(map[string]struct{}, len(pages))
+	for _, p := range pages {
+		existing[pagestore.NormalizeTitle(p)] = struct{}{}
+	}
+	has := func(title string) bool {
+		_, ok := existing[pagestore.NormalizeTitle(title)]
+		return ok
+	}
+
+	report := &LintReport{Counts: make(map[LintCategory]int)}
+	add := func(f LintFinding) {
+		report.Findings = append(report.Findings, f)
+		report.Counts[f.Category]++
+	}
+	missingSiblings := func(root string) []string {
+		var missing []string
+		for _, sub := range requiredSubpages {
+			if !has(root + "/" + sub) {
+				missing = append(missing, sub)
+			}
+		}
+		return missing
+	}
+
+	// the content of version pages is needed to classify them; read it in
+	// batches instead of page by page
+	var versionPages []string
+	for _, title := range pages {
+		if pkg, pageType, _ := parseVPMPageTitle(title); pageType == "version" {
+			if _, ok := allVersionsMap[pkg]; ok {
+				versionPages = append(versionPages, title)
+			}
+		}
+	}
+	contents, err := s.store.GetMany(ctx, versionPages)
+	if err != nil {
+		return nil, fmt.Errorf("read version pages: %w", err)
+	}
+
+	for _, title := range pages {
+		if _, ok := skipSet[pagestore.NormalizeTitle(title)]; ok {
+			continue
+		}
+		pkg, pageType, _ := parseVPMPageTitle(title)
+		if pkg == "" {
+			add(LintFinding{Title: title, Category: LintUnexpectedSubpage, Detail: "unrecognised page title"})
+			continue
+		}
+		if _, ok := allVersionsMap[pkg]; !ok {
+			add(LintFinding{Title: title, Package: pkg, Category: LintUnknownPackage, Detail: "package not in VPMM index"})
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(title, "Template:VPM/"), "/")
+
+		switch pageType {
+		case "latest_version", "latest_stable_version", "latest_unstable_version":
+			if missing := missingSiblings(title); len(missing) > 0 {
+				add(LintFinding{Title: title, Package: pkg, Category: LintMissingSubpages, Detail: "missing " + strings.Join(missing, ", ")})
+				continue
+			}
+			add(LintFinding{Title: title, Package: pkg, Category: LintValid})
+
+		case "version":
+			page, ok := contents[title]
+			if !ok {
+				// deleted since it was listed
+				continue
+			}
+			content := page.Content
+			v, err := semver.StrictNewVersion(strings.TrimSpace(content))
+			if err != nil {
+				add(LintFinding{Title: title, Package: pkg, Category: LintNonSemverContent, Detail: fmt.Sprintf("content %q is not a semantic version", strings.TrimSpace(content))})
+				continue
+			}
+			known := false
+			for _, pv := range allVersionsMap[pkg] {
+				if pv.Version == v.String() {
+					known = true
+					break
+				}
+			}
+			if !known {
+				add(LintFinding{Title: title, Package: pkg, Category: LintUnknownVersion, Detail: fmt.Sprintf("version %s not in VPMM index", v.String())})
+				continue
+			}
+			if missing := missingSiblings(title); len(missing) > 0 {
+				add(LintFinding{Title: title, Package: pkg, Category: LintMissingSubpages, Detail: "missing " + strings.Join(missing, ", ")})
+				continue
+			}
+			add(LintFinding{Title: title, Package: pkg, Category: LintValid})
+
+		default:
+			// subpage of a Latest_* or specific version page
+			root := "Template:VPM/" + pkg + "/" + parts[1]
+			switch {
+			case len(parts) > 3:
+				add(LintFinding{Title: title, Package: pkg, Category: LintUnexpectedSubpage, Detail: "nested too deep"})
+			case !isKnownSubpage(parts[2]):
+				add(LintFinding{Title: title, Package: pkg, Category: LintUnexpectedSubpage, Detail: fmt.Sprintf("unknown subpage %q", parts[2])})
+			case !has(root):
+				add(LintFinding{Title: title, Package: pkg, Category: LintUnexpectedSubpage, Detail: "orphaned: parent page missing"})
+			default:
+				add(LintFinding{Title: title, Package: pkg, Category: LintValid})
+			}
+		}
+	}
+
+	sort.Slice(report.Findings, func(i, j int) bool { return report.Findings[i].Title < report.Findings[j].Title })
+	return report, nil
+}
+
+// RenderLintReportWikiTable renders the non-valid findings of a report as a
+// MediaWiki table preceded by a per-category count list.
+func RenderLintReportWikiTable(report *LintReport) string {
+	var sb strings.Builder
+	for _, cat := range lintCategoryOrder {
+		sb.WriteString(fmt.Sprintf("* %s: %d\n", cat, report.Counts[cat]))
+	}
+	sb.WriteString("\n")
+	sb.WriteString("{| class=\"wikitable sortable\"\n")
+	sb.WriteString("|-\n")
+	sb.WriteString("! Page\n")
+	sb.WriteString("! Package\n")
+	sb.WriteString("! Category\n")
+	sb.WriteString("! Detail\n")
+	for _, f := range report.Findings {
+		if f.Category == LintValid {
+			continue
+		}
+		sb.WriteString("|-\n")
+		sb.WriteString(fmt.Sprintf("| [[%s]]\n", f.Title))
+		sb.WriteString(fmt.Sprintf("| %s\n", sanitizeForWiki(f.Package)))
+		sb.WriteString(fmt.Sprintf("| %s\n", f.Category))
+		sb.WriteString(fmt.Sprintf("| %s\n", sanitizeForWiki(f.Detail)))
+	}
+	sb.WriteString("|}\n")
+	return sb.String()
+}
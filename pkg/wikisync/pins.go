@@ -0,0 +1,51 @@
+package wikisync
+
+import (
+	"strings"
+
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// WithPins returns a copy of s that writes pinned content instead of the
+// package metadata. pins maps package names to field subpages (e.g.
+// "Description" or "Author_1") and the wikitext they hold for every version
+// of the package; it is written as is, without escaping. Pins only replace
+// the content of pages the Syncer writes anyway, and pinned pages are never
+// deleted.
+func (s *Syncer) WithPins(pins map[string]map[string]string) *Syncer {
+	cp := *s
+	cp.pins = make(map[string]map[string]string, len(pins))
+	for name, fields := range pins {
+		key := pagestore.NormalizeTitle(name)
+		if cp.pins[key] == nil {
+			cp.pins[key] = make(map[string]string, len(fields))
+		}
+		for field, content := range fields {
+			cp.pins[key][pagestore.NormalizeTitle(field)] = content
+		}
+	}
+	return &cp
+}
+
+// Filter returns the filter set with WithFilter.
+func (s *Syncer) Filter() Filter {
+	return s.filter
+}
+
+// pinned returns the pinned content of a Template:VPM/<package>/<version>/<field>
+// page, if any.
+func (s *Syncer) pinned(title string) (string, bool) {
+	if len(s.pins) == 0 {
+		return "", false
+	}
+	rest, ok := strings.CutPrefix(title, "Template:VPM/")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+	content, ok := s.pins[pagestore.NormalizeTitle(parts[0])][pagestore.NormalizeTitle(parts[2])]
+	return content, ok
+}
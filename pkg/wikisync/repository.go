@@ -0,0 +1,75 @@
+package wikisync
+
+import (
+	"context"
+	"fmt"
+	"maps"
+)
+
+// Repository identifies the repository listing a package was taken from.
+type Repository struct {
+	ID   string
+	Name string
+	URL  string
+}
+
+// label is the human-readable repository name written to the wiki.
+func (r Repository) label() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.ID != "":
+		return r.ID
+	default:
+		return r.URL
+	}
+}
+
+// repositorySubpages are the subpages recording a package's repository.
+var repositorySubpages = []string{"Repository", "Repository URL"}
+
+// WithRepositories returns a copy of s that records, for each package name in
+// repos, which repository it came from on the Repository and Repository_URL
+// subpages of every version it updates. Like the version pages themselves,
+// these subpages are only kept up to date once someone created them on the
+// wiki.
+func (s *Syncer) WithRepositories(repos map[string]Repository) *Syncer {
+	cp := *s
+	cp.repositories = maps.Clone(repos)
+	return &cp
+}
+
+// repositoryPages returns the repository subpages for a version path, or nil
+// when the package's repository is unknown.
+func (s *Syncer) repositoryPages(packageName, versionPath string) map[string]string {
+	repo, ok := s.repositories[packageName]
+	if !ok || repo.label() == "" {
+		return nil
+	}
+	pages := map[string]string{
+		fmt.Sprintf("Template:VPM/%s/%s/Repository", packageName, versionPath): sanitizeForWiki(repo.label()),
+	}
+	if repo.URL != "" {
+		pages[fmt.Sprintf("Template:VPM/%s/%s/Repository_URL", packageName, versionPath)] = sanitizeForWiki(repo.URL)
+	}
+	return pages
+}
+
+// updateRepositoryPages updates the repository subpages of a version path
+// that exist on the wiki.
+func (s *Syncer) updateRepositoryPages(ctx context.Context, packageName, versionPath string) error {
+	for title, content := range s.repositoryPages(packageName, versionPath) {
+		// gate: only update if the subpage already exists
+		exists, err := s.pageExists(ctx, title)
+		if err != nil {
+			return fmt.Errorf("check existence for %s: %w", title, err)
+		}
+		if !exists {
+			continue
+		}
+		if err := s.EditPage(ctx, title, content, true); err != nil {
+			return fmt.Errorf("update repository page: %w", err)
+		}
+	}
+	return nil
+}
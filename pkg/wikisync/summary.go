@@ -1,7 +1,8 @@
-package mediawiki
+package wikisync
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -23,6 +24,7 @@ type PackageVersionSummary struct {
 }
 
 // ComputeLatestStableUnstable computes latest, stable-only, and unstable-only maps from all versions per package.
+// Non-semver versions are ignored; ties are broken by CompareVersions so the result does not depend on input order.
 func ComputeLatestStableUnstable(allVersions map[string][]apiclient.Package) (map[string]apiclient.Package, map[string]apiclient.Package, map[string]apiclient.Package) {
 	latest := make(map[string]apiclient.Package)
 	stable := make(map[string]apiclient.Package)
@@ -44,21 +46,21 @@ func ComputeLatestStableUnstable(allVersions map[string][]apiclient.Package) (ma
 				continue
 			}
 			// latest
-			if bestLatest == nil || sv.GreaterThan(bestLatest) {
+			if bestLatest == nil || CompareVersions(v.Version, bestLatestPV.Version) > 0 {
 				cp := v
 				bestLatest = sv
 				bestLatestPV = cp
 			}
 			// stable
 			if sv.Prerelease() == "" {
-				if bestStable == nil || sv.GreaterThan(bestStable) {
+				if bestStable == nil || CompareVersions(v.Version, bestStablePV.Version) > 0 {
 					cp := v
 					bestStable = sv
 					bestStablePV = cp
 				}
 			} else {
 				// unstable
-				if bestUnstable == nil || sv.GreaterThan(bestUnstable) {
+				if bestUnstable == nil || CompareVersions(v.Version, bestUnstablePV.Version) > 0 {
 					cp := v
 					bestUnstable = sv
 					bestUnstablePV = cp
@@ -96,15 +98,17 @@ func GetVersionSummaryTableWithWikiVersions(wikiVersionsMap map[string][]string,
 	for n := range nameSet {
 		names = append(names, n)
 	}
-	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	sort.Slice(names, func(i, j int) bool {
+		li, lj := strings.ToLower(names[i]), strings.ToLower(names[j])
+		if li != lj {
+			return li < lj
+		}
+		return names[i] < names[j]
+	})
 
 	var summaries []PackageVersionSummary
 	for _, name := range names {
-		display := name
-		if vs := allVersionsMap[name]; len(vs) > 0 && strings.TrimSpace(vs[0].DisplayName) != "" {
-			display = vs[0].DisplayName
-		}
-		s := PackageVersionSummary{Name: name, DisplayName: display}
+		s := PackageVersionSummary{Name: name, DisplayName: displayName(name, latestMap, allVersionsMap[name])}
 		if v, ok := latestMap[name]; ok {
 			vv := v
 			s.LatestVersion = &vv
@@ -126,18 +130,11 @@ func GetVersionSummaryTableWithWikiVersions(wikiVersionsMap map[string][]string,
 			}
 			var filtered []string
 			for _, wv := range wikiV {
-				if _, ok := known[wv]; ok {
+				if _, ok := known[wv]; ok && !slices.Contains(filtered, wv) {
 					filtered = append(filtered, wv)
 				}
 			}
-			sort.Slice(filtered, func(i, j int) bool {
-				vi, ei := semver.NewVersion(filtered[i])
-				vj, ej := semver.NewVersion(filtered[j])
-				if ei != nil || ej != nil {
-					return strings.Compare(filtered[i], filtered[j]) < 0
-				}
-				return vi.LessThan(vj)
-			})
+			slices.SortFunc(filtered, CompareVersions)
 			s.WikiVersions = filtered
 		}
 		summaries = append(summaries, s)
@@ -145,6 +142,22 @@ func GetVersionSummaryTableWithWikiVersions(wikiVersionsMap map[string][]string,
 	return summaries, nil
 }
 
+// displayName returns the display name of the latest version of a package,
+// falling back to the newest version that has one and finally to the package name.
+func displayName(name string, latestMap map[string]apiclient.Package, versions []apiclient.Package) string {
+	if v, ok := latestMap[name]; ok && strings.TrimSpace(v.DisplayName) != "" {
+		return v.DisplayName
+	}
+	sorted := slices.Clone(versions)
+	SortPackagesByVersionDesc(sorted)
+	for _, v := range sorted {
+		if strings.TrimSpace(v.DisplayName) != "" {
+			return v.DisplayName
+		}
+	}
+	return name
+}
+
 // GenerateVersionSummaryWikiTableWithWikiVersions renders a MediaWiki table with version information.
 func GenerateVersionSummaryWikiTableWithWikiVersions(wikiVersionsMap map[string][]string, allVersionsMap map[string][]apiclient.Package) (string, error) {
 	summaries, err := GetVersionSummaryTableWithWikiVersions(wikiVersionsMap, allVersionsMap)
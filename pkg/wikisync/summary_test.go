@@ -0,0 +1,127 @@
+package wikisync
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	apiclient "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with the golden file at path, or rewrites the
+// file when the test runs with -update.
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s (run go test -update to accept it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// summaryCase is the input of a version summary golden test.
+type summaryCase struct {
+	Packages []apiclient.Package `json:"packages"`
+	Wiki     map[string][]string `json:"wiki"`
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+}
+
+func TestGenerateVersionSummaryGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "summary", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test cases in testdata/summary")
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			var tc summaryCase
+			readJSON(t, input, &tc)
+			got, err := GenerateVersionSummaryWikiTableWithWikiVersions(tc.Wiki, BuildAllVersionsMapFromAPI(tc.Packages))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, strings.TrimSuffix(input, ".json")+".golden", got)
+
+			// the order of the index and of the wiki listing must not show
+			// up in the table, or unchanged data would produce edits
+			slices.Reverse(tc.Packages)
+			for _, versions := range tc.Wiki {
+				slices.Reverse(versions)
+			}
+			reordered, err := GenerateVersionSummaryWikiTableWithWikiVersions(tc.Wiki, BuildAllVersionsMapFromAPI(tc.Packages))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reordered != got {
+				t.Errorf("table depends on input order\nreversed input:\n%s\noriginal input:\n%s", reordered, got)
+			}
+		})
+	}
+}
+
+func TestSortPackagesByVersionDescGolden(t *testing.T) {
+	var versions []string
+	readJSON(t, filepath.Join("testdata", "versions.json"), &versions)
+
+	// CompareVersions must be a total order for the sort to be meaningful
+	for _, a := range versions {
+		for _, b := range versions {
+			if ab, ba := CompareVersions(a, b), CompareVersions(b, a); ab != -ba {
+				t.Errorf("CompareVersions(%q, %q) = %d but CompareVersions(%q, %q) = %d", a, b, ab, b, a, ba)
+			}
+			for _, c := range versions {
+				if CompareVersions(a, b) < 0 && CompareVersions(b, c) < 0 && CompareVersions(a, c) >= 0 {
+					t.Errorf("CompareVersions is not transitive for %q < %q < %q", a, b, c)
+				}
+			}
+		}
+	}
+
+	render := func(versions []string) string {
+		pkgs := make([]apiclient.Package, len(versions))
+		for i, v := range versions {
+			pkgs[i] = apiclient.Package{Name: "com.example.pkg", Version: v}
+		}
+		SortPackagesByVersionDesc(pkgs)
+		var sb strings.Builder
+		for _, p := range pkgs {
+			fmt.Fprintf(&sb, "%q\n", p.Version)
+		}
+		return sb.String()
+	}
+	got := render(versions)
+	checkGolden(t, filepath.Join("testdata", "versions.golden"), got)
+	reversed := slices.Clone(versions)
+	slices.Reverse(reversed)
+	if reversed := render(reversed); reversed != got {
+		t.Errorf("order depends on input order\nreversed input:\n%s\noriginal input:\n%s", reversed, got)
+	}
+}
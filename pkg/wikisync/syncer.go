@@ -0,0 +1,437 @@
+package wikisync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/internal/logging"
+	apiclient "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+	"github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/pagestore"
+)
+
+// Syncer keeps the Template:VPM/* pages of a PageStore in sync with VPMM
+// package metadata.
+type Syncer struct {
+	store  pagestore.PageStore
+	logger *slog.Logger
+
+	// repositories maps package names to their origin; see WithRepositories
+	repositories map[string]Repository
+	// filter selects the packages to sync; see WithFilter
+	filter Filter
+	// dryRun, if set, suppresses writes; see WithDryRun
+	dryRun *dryRun
+	// pins maps normalized package and field names to pinned content; see
+	// WithPins
+	pins map[string]map[string]string
+}
+
+// NewSyncer returns a Syncer writing to store. A nil logger disables logging.
+// Log calls use the caller's context, so correlation attributes attached with
+// logging.With appear on every line.
+func NewSyncer(store pagestore.PageStore, logger *slog.Logger) *Syncer {
+	return &Syncer{store: store, logger: logging.OrDiscard(logger)}
+}
+
+// Store returns the PageStore the Syncer works against.
+func (s *Syncer) Store() pagestore.PageStore {
+	return s.store
+}
+
+// WithStore returns a copy of the Syncer working against store, e.g. a
+// wrapper of Store that limits or records writes.
+func (s *Syncer) WithStore(store pagestore.PageStore) *Syncer {
+	cp := *s
+	cp.store = store
+	return &cp
+}
+
+// UpdateSinglePackage performs a create-or-update flow for a package's Latest_version subtree.
+// Unlike the gated helpers, this will create missing pages as needed.
+func (s *Syncer) UpdateSinglePackage(ctx context.Context, pkg apiclient.Package) error {
+	packageName := pkg.Name
+	ctx = logging.With(ctx, logging.KeyPackage, packageName)
+	updated := 0
+	// helpers for optional fields
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+	pagesToUpdate := map[string]string{
+		fmt.Sprintf("Template:VPM/%s/Latest_version", packageName):             sanitizeForWiki(pkg.Version),
+		fmt.Sprintf("Template:VPM/%s/Latest_version/Description", packageName): sanitizeForWiki(str(pkg.Description)),
+		fmt.Sprintf("Template:VPM/%s/Latest_version/DisplayName", packageName): sanitizeForWiki(pkg.DisplayName),
+		fmt.Sprintf("Template:VPM/%s/Latest_version/License", packageName):     sanitizeForWiki(str(pkg.License)),
+	}
+	if pkg.Author.Name != nil && *pkg.Author.Name != "" {
+		authors := strings.Split(*pkg.Author.Name, ",")
+		if len(authors) > 4 {
+			authors = authors[:4]
+		}
+		for i, author := range authors {
+			author = strings.TrimSpace(author)
+			if author != "" {
+				pagesToUpdate[fmt.Sprintf("Template:VPM/%s/Latest_version/Author_%d", packageName, i+1)] = sanitizeForWiki(author)
+			}
+		}
+	}
+	for title, newContent := range pagesToUpdate {
+		currentContent, err := s.getPageContent(ctx, title)
+		if err != nil {
+			// ignore read errors but proceed to write
+			currentContent = ""
+		}
+		if strings.TrimSpace(currentContent) != strings.TrimSpace(newContent) {
+			if err := s.EditPage(ctx, title, newContent, true); err == nil {
+				updated++
+			}
+		}
+	}
+	if err := s.updateRepositoryPages(ctx, packageName, "Latest_version"); err != nil {
+		s.logger.WarnContext(ctx, "update repository pages", "error", err)
+	}
+	s.logger.InfoContext(ctx, "wiki package updated", "updated", updated)
+	return nil
+}
+
+// EditPage writes a page unless its trimmed content is already up to date.
+// Pinned content replaces text; see WithPins.
+func (s *Syncer) EditPage(ctx context.Context, title, text string, bot bool) error {
+	ctx = logging.With(ctx, logging.KeyPage, title)
+	if pin, ok := s.pinned(title); ok {
+		text = pin
+	}
+	trimmedNew := strings.TrimSpace(text)
+	currentContent, err := s.getPageContent(ctx, title)
+	if err != nil {
+		if !pagestore.IsNotFound(err) {
+			return fmt.Errorf("get current content for page %s: %w", title, err)
+		}
+		currentContent = ""
+	} else {
+		trimmedCurrent := strings.TrimSpace(currentContent)
+		if trimmedCurrent == trimmedNew {
+			s.logger.DebugContext(ctx, "wiki page up to date")
+			return nil
+		}
+	}
+	summary := buildEditSummary(title, trimmedNew)
+	if s.skipWrite(title) {
+		s.logger.InfoContext(ctx, "dry run: page would be written", "create", err != nil,
+			"old_bytes", len(currentContent), "new_bytes", len(text), "summary", summary)
+		return nil
+	}
+	return s.store.Put(ctx, title, text, pagestore.PutOptions{Summary: summary, Bot: bot})
+}
+
+func (s *Syncer) getPageContent(ctx context.Context, title string) (string, error) {
+	page, err := s.store.Get(ctx, title)
+	if err != nil {
+		return "", err
+	}
+	return page.Content, nil
+}
+
+// DeletePage deletes a wiki page by title with an optional reason. Pinned
+// pages are kept.
+func (s *Syncer) DeletePage(ctx context.Context, title string, reason string) error {
+	ctx = logging.With(ctx, logging.KeyPage, title)
+	if _, ok := s.pinned(title); ok {
+		s.logger.DebugContext(ctx, "pinned page kept", "reason", reason)
+		return nil
+	}
+	if s.skipWrite(title) {
+		s.logger.InfoContext(ctx, "dry run: page would be deleted", "reason", reason)
+		return nil
+	}
+	return s.store.Delete(ctx, title, reason)
+}
+
+// pageExists returns true if the given page exists on the wiki.
+// It uses getPageContent and interprets pagestore.ErrNotFound as non-existence.
+func (s *Syncer) pageExists(ctx context.Context, title string) (bool, error) {
+	_, err := s.getPageContent(ctx, title)
+	if err == nil {
+		return true, nil
+	}
+	if pagestore.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// ProcessSpecificVersionPage handles a specific version page (semver-only).
+// Gated: only updates when the specific version page already exists.
+func (s *Syncer) ProcessSpecificVersionPage(ctx context.Context, packageName, versionTag string, knownVersions map[string]apiclient.Package) error {
+	versionPageTitle := fmt.Sprintf("Template:VPM/%s/%s", packageName, versionTag)
+	// gate: only proceed if the specific version page already exists
+	exists, err := s.pageExists(ctx, versionPageTitle)
+	if err != nil {
+		return fmt.Errorf("check existence for %s: %w", versionPageTitle, err)
+	}
+	if !exists {
+		return nil
+	}
+	// read version from the page content, allowing free-form page names
+	content, err := s.getPageContent(ctx, versionPageTitle)
+	if err != nil {
+		return fmt.Errorf("read content from %s: %w", versionPageTitle, err)
+	}
+	v, err := semver.StrictNewVersion(strings.TrimSpace(content))
+	if err != nil {
+		s.logger.WarnContext(ctx, "non-semver version content on page", "package", packageName, "page", versionPageTitle, "content", strings.TrimSpace(content))
+		return nil
+	}
+	// if known, update subpages for this version (main page content is the source of truth)
+	if pkgVersion, ok := knownVersions[v.String()]; ok {
+		return s.updateVersionSubpages(ctx, packageName, versionTag, pkgVersion)
+	}
+	s.logger.InfoContext(ctx, "version from page content not found in known versions", "package", packageName, "version", v.String(), "page", versionPageTitle)
+	return nil
+}
+
+// updateVersionSubpages updates the subpages for a version (either Latest_* or specific version tag)
+func (s *Syncer) updateVersionSubpages(ctx context.Context, packageName, versionPath string, version apiclient.Package) error {
+	// helpers
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+
+	// Description
+	descTitle := fmt.Sprintf("Template:VPM/%s/%s/Description", packageName, versionPath)
+	if err := s.EditPage(ctx, descTitle, sanitizeForWiki(str(version.Description)), true); err != nil {
+		return fmt.Errorf("update description page: %w", err)
+	}
+	// DisplayName
+	dnTitle := fmt.Sprintf("Template:VPM/%s/%s/DisplayName", packageName, versionPath)
+	if err := s.EditPage(ctx, dnTitle, sanitizeForWiki(version.DisplayName), true); err != nil {
+		return fmt.Errorf("update display name page: %w", err)
+	}
+	// License
+	licTitle := fmt.Sprintf("Template:VPM/%s/%s/License", packageName, versionPath)
+	if err := s.EditPage(ctx, licTitle, sanitizeForWiki(str(version.License)), true); err != nil {
+		return fmt.Errorf("update license page: %w", err)
+	}
+	// Repository (only when the package's origin is known and the subpages exist)
+	if err := s.updateRepositoryPages(ctx, packageName, versionPath); err != nil {
+		return err
+	}
+
+	// Authors handling
+	authorName := str(version.Author.Name)
+	if strings.TrimSpace(authorName) != "" {
+		authors := strings.Split(authorName, ",")
+		for i := range authors {
+			authors[i] = strings.TrimSpace(authors[i])
+		}
+		if len(authors) > 4 {
+			authors = authors[:4]
+		}
+		for i, author := range authors {
+			if author == "" {
+				continue
+			}
+			aTitle := fmt.Sprintf("Template:VPM/%s/%s/Author_%d", packageName, versionPath, i+1)
+			if err := s.EditPage(ctx, aTitle, sanitizeForWiki(author), true); err != nil {
+				return fmt.Errorf("update Author_%d page: %w", i+1, err)
+			}
+		}
+		// cleanup any leftover author pages up to 4
+		for i := len(authors) + 1; i <= 4; i++ {
+			aTitle := fmt.Sprintf("Template:VPM/%s/%s/Author_%d", packageName, versionPath, i)
+			if _, err := s.getPageContent(ctx, aTitle); err == nil {
+				_ = s.DeletePage(ctx, aTitle, "Author removed from package")
+			}
+		}
+	} else {
+		// no authors; cleanup possible existing pages 1..4
+		for i := 1; i <= 4; i++ {
+			aTitle := fmt.Sprintf("Template:VPM/%s/%s/Author_%d", packageName, versionPath, i)
+			if _, err := s.getPageContent(ctx, aTitle); err == nil {
+				_ = s.DeletePage(ctx, aTitle, "Author removed from package")
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateLatestVersionPages updates the Latest_version page and its subpages for a package.
+// Gated: only updates when the Latest_version page already exists.
+func (s *Syncer) UpdateLatestVersionPages(ctx context.Context, version apiclient.Package) error {
+	pkg := version.Name
+	title := fmt.Sprintf("Template:VPM/%s/Latest_version", pkg)
+	// gate: only update if main page already exists
+	exists, err := s.pageExists(ctx, title)
+	if err != nil {
+		return fmt.Errorf("check existence for %s: %w", title, err)
+	}
+	if !exists {
+		return nil
+	}
+	if err := s.EditPage(ctx, title, sanitizeForWiki(version.Version), true); err != nil {
+		return fmt.Errorf("update latest version page: %w", err)
+	}
+	return s.updateVersionSubpages(ctx, pkg, "Latest_version", version)
+}
+
+// UpdateLatestStableVersionPages updates the Latest_stable_version page and its subpages.
+// Gated: only updates when the Latest_stable_version page already exists.
+func (s *Syncer) UpdateLatestStableVersionPages(ctx context.Context, version apiclient.Package) error {
+	pkg := version.Name
+	title := fmt.Sprintf("Template:VPM/%s/Latest_stable_version", pkg)
+	// gate: only update if main page already exists
+	exists, err := s.pageExists(ctx, title)
+	if err != nil {
+		return fmt.Errorf("check existence for %s: %w", title, err)
+	}
+	if !exists {
+		return nil
+	}
+	if err := s.EditPage(ctx, title, sanitizeForWiki(version.Version), true); err != nil {
+		return fmt.Errorf("update latest stable version page: %w", err)
+	}
+	return s.updateVersionSubpages(ctx, pkg, "Latest_stable_version", version)
+}
+
+// UpdateLatestUnstableVersionPages updates the Latest_unstable_version page and its subpages.
+// Gated: only updates when the Latest_unstable_version page already exists.
+func (s *Syncer) UpdateLatestUnstableVersionPages(ctx context.Context, version apiclient.Package) error {
+	pkg := version.Name
+	title := fmt.Sprintf("Template:VPM/%s/Latest_unstable_version", pkg)
+	// gate: only update if main page already exists
+	exists, err := s.pageExists(ctx, title)
+	if err != nil {
+		return fmt.Errorf("check existence for %s: %w", title, err)
+	}
+	if !exists {
+		return nil
+	}
+	if err := s.EditPage(ctx, title, sanitizeForWiki(version.Version), true); err != nil {
+		return fmt.Errorf("update latest unstable version page: %w", err)
+	}
+	return s.updateVersionSubpages(ctx, pkg, "Latest_unstable_version", version)
+}
+
+// ScanVpmPages scans the wiki for all Template:VPM/* pages and returns
+// a map of package -> pages and a map of package -> known version tags on the wiki.
+func (s *Syncer) ScanVpmPages(ctx context.Context) (map[string][]string, map[string][]string, error) {
+	pages, err := s.store.List(ctx, "Template:VPM/")
+	if err != nil {
+		return nil, nil, err
+	}
+	packagePages := make(map[string][]string)
+	wikiVersions := make(map[string][]string)
+	for _, p := range pages {
+		pkg, pageType, versionTag := parseVPMPageTitle(p)
+		if pkg == "" {
+			continue
+		}
+		packagePages[pkg] = append(packagePages[pkg], p)
+		if pageType == "version" && strings.TrimSpace(versionTag) != "" {
+			// add if not already present
+			exists := slices.Contains(wikiVersions[pkg], versionTag)
+			if !exists {
+				wikiVersions[pkg] = append(wikiVersions[pkg], versionTag)
+			}
+		}
+	}
+	return packagePages, wikiVersions, nil
+}
+
+// SyncExistingPages updates only those pages whose main pages already exist on the wiki.
+// It mirrors the legacy behavior: Latest_*, Latest_* subpages, and specific version subpages
+// are updated only when their corresponding main page exists. Packages the filter does not
+// select are skipped.
+func (s *Syncer) SyncExistingPages(
+	ctx context.Context,
+	latest map[string]apiclient.Package,
+	stable map[string]apiclient.Package,
+	unstable map[string]apiclient.Package,
+	allByPkg map[string]map[string]apiclient.Package,
+) error {
+	packagePages, wikiVersionsMap, err := s.ScanVpmPages(ctx)
+	if err != nil {
+		return err
+	}
+	// union of package names
+	nameSet := make(map[string]struct{})
+	for n := range packagePages {
+		nameSet[n] = struct{}{}
+	}
+	for n := range latest {
+		nameSet[n] = struct{}{}
+	}
+	for n := range stable {
+		nameSet[n] = struct{}{}
+	}
+	for n := range unstable {
+		nameSet[n] = struct{}{}
+	}
+	var errs []string
+	for name := range nameSet {
+		var pkg *apiclient.Package
+		if v, ok := latest[name]; ok {
+			pkg = &v
+		}
+		if !s.Selects(name, pkg) {
+			s.logger.DebugContext(logging.With(ctx, logging.KeyPackage, name), "package filtered out")
+			continue
+		}
+		pages := packagePages[name]
+		has := func(title string) bool {
+			// the wiki lists titles with spaces, we build them with underscores
+			return slices.ContainsFunc(pages, func(p string) bool {
+				return pagestore.NormalizeTitle(p) == pagestore.NormalizeTitle(title)
+			})
+		}
+		// Latest version
+		if v, ok := latest[name]; ok {
+			title := fmt.Sprintf("Template:VPM/%s/Latest_version", name)
+			if has(title) {
+				if err := s.UpdateLatestVersionPages(ctx, v); err != nil {
+					errs = append(errs, fmt.Sprintf("latest %s: %v", name, err))
+				}
+			}
+		}
+		// Latest stable
+		if v, ok := stable[name]; ok {
+			title := fmt.Sprintf("Template:VPM/%s/Latest_stable_version", name)
+			if has(title) {
+				if err := s.UpdateLatestStableVersionPages(ctx, v); err != nil {
+					errs = append(errs, fmt.Sprintf("stable %s: %v", name, err))
+				}
+			}
+		}
+		// Latest unstable
+		if v, ok := unstable[name]; ok {
+			title := fmt.Sprintf("Template:VPM/%s/Latest_unstable_version", name)
+			if has(title) {
+				if err := s.UpdateLatestUnstableVersionPages(ctx, v); err != nil {
+					errs = append(errs, fmt.Sprintf("unstable %s: %v", name, err))
+				}
+			}
+		}
+		// Specific version pages discovered on the wiki
+		known := allByPkg[name]
+		if versions, ok := wikiVersionsMap[name]; ok && len(versions) > 0 && known != nil {
+			for _, tag := range versions {
+				if err := s.ProcessSpecificVersionPage(ctx, name, tag, known); err != nil {
+					errs = append(errs, fmt.Sprintf("version %s/%s: %v", name, tag, err))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("sync existing pages: %d errors:\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package wikisync
+
+import (
+	"fmt"
+	"strings"
+)
+
+func sanitizeForWiki(text string) string {
+	text = strings.ReplaceAll(text, "|", "{{!}}")
+	text = strings.ReplaceAll(text, "=", "{{=}}")
+	return text
+}
+
+const maxEditSummaryLen = 120
+
+func clipSummary(summary string) string {
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "sync wiki page"
+	}
+	runes := []rune(summary)
+	if len(runes) <= maxEditSummaryLen {
+		return summary
+	}
+	return string(runes[:maxEditSummaryLen-3]) + "..."
+}
+
+func shortFieldName(field string) string {
+	switch strings.ToLower(strings.TrimSpace(field)) {
+	case "description":
+		return "description"
+	case "displayname":
+		return "display-name"
+	case "license":
+		return "license"
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(field)), "author_") {
+		return "author"
+	}
+	return strings.TrimSpace(field)
+}
+
+func buildEditSummary(title, newText string) string {
+	if strings.EqualFold(strings.TrimSpace(title), VersionSummaryPageTitle) {
+		return "sync version summary"
+	}
+
+	const prefix = "Template:VPM/"
+	if !strings.HasPrefix(title, prefix) {
+		return clipSummary(fmt.Sprintf("sync %s", title))
+	}
+
+	parts := strings.Split(strings.TrimPrefix(title, prefix), "/")
+	if len(parts) < 2 {
+		return clipSummary(fmt.Sprintf("sync %s", title))
+	}
+
+	packageName := strings.TrimSpace(parts[0])
+	section := strings.TrimSpace(parts[1])
+	trimmedNew := strings.TrimSpace(newText)
+	if packageName == "" || section == "" {
+		return clipSummary(fmt.Sprintf("sync %s", title))
+	}
+
+	switch section {
+	case "Latest_version":
+		section = "latest"
+	case "Latest_stable_version":
+		section = "stable"
+	case "Latest_unstable_version":
+		section = "unstable"
+	}
+
+	if len(parts) == 2 {
+		if section == "latest" || section == "stable" || section == "unstable" {
+			if trimmedNew == "" {
+				return clipSummary(fmt.Sprintf("%s %s", packageName, section))
+			}
+			return clipSummary(fmt.Sprintf("%s %s=%s", packageName, section, trimmedNew))
+		}
+		if trimmedNew == "" {
+			return clipSummary(fmt.Sprintf("%s version=%s", packageName, section))
+		}
+		return clipSummary(fmt.Sprintf("%s version=%s", packageName, trimmedNew))
+	}
+
+	field := shortFieldName(parts[2])
+	return clipSummary(fmt.Sprintf("%s %s %s", packageName, section, field))
+}
+
+// parseVPMPageTitle parses a VPM page title and extracts package name, page type, and version/tag.
+// Returns: packageName, pageType, versionTag
+func parseVPMPageTitle(title string) (string, string, string) {
+	if !strings.HasPrefix(title, "Template:VPM/") {
+		return "", "", ""
+	}
+	remainder := strings.TrimPrefix(title, "Template:VPM/")
+	parts := strings.Split(remainder, "/")
+	if len(parts) < 2 {
+		return "", "", ""
+	}
+	packageName := parts[0]
+
+	normalize := func(s string) string {
+		return strings.ReplaceAll(s, "_", " ")
+	}
+	second := normalize(parts[1])
+
+	switch second {
+	case "Latest version":
+		if len(parts) == 2 {
+			return packageName, "latest_version", ""
+		}
+		return packageName, "latest_version_subpage", parts[2]
+	case "Latest stable version":
+		if len(parts) == 2 {
+			return packageName, "latest_stable_version", ""
+		}
+		return packageName, "latest_stable_version_subpage", parts[2]
+	case "Latest unstable version":
+		if len(parts) == 2 {
+			return packageName, "latest_unstable_version", ""
+		}
+		return packageName, "latest_unstable_version_subpage", parts[2]
+	default:
+		versionTag := parts[1]
+		if len(parts) == 2 {
+			// must be a specific version page; ensure semver-only checking is handled by caller
+			// but we still return it here
+			return packageName, "version", versionTag
+		}
+		return packageName, "version_subpage", versionTag
+	}
+}
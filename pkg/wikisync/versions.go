@@ -0,0 +1,50 @@
+package wikisync
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	apiclient "github.com/hackebein/vpmm/apps/vrcwiki-connector/pkg/apiclient"
+)
+
+// CompareVersions defines the total order used for package versions across
+// the connector. It returns -1, 0 or +1 when a sorts before, equal to, or
+// after b in ascending order:
+//
+//   - parseable semantic versions sort by semver precedence, so prereleases
+//     sort before their release;
+//   - versions with equal precedence (differing only in build metadata or
+//     spelling such as a leading "v") are ordered by their trimmed strings;
+//   - any semantic version sorts after every non-semver string;
+//   - non-semver strings are ordered by plain string comparison;
+//   - strings equal after trimming are ordered by their untrimmed form, so
+//     only identical strings compare equal.
+func CompareVersions(a, b string) int {
+	ta, tb := strings.TrimSpace(a), strings.TrimSpace(b)
+	va, errA := semver.NewVersion(ta)
+	vb, errB := semver.NewVersion(tb)
+	var c int
+	switch {
+	case errA == nil && errB == nil:
+		c = va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	if c != 0 {
+		return c
+	}
+	if c := strings.Compare(ta, tb); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// SortPackagesByVersionDesc sorts packages in-place by CompareVersions, newest first.
+func SortPackagesByVersionDesc(pkgs []apiclient.Package) {
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		return CompareVersions(pkgs[i].Version, pkgs[j].Version) > 0
+	})
+}